@@ -2,26 +2,92 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/config"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/search"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/xkcd"
+
+	"github.com/joho/godotenv"
 )
 
-var cfg config.Config
+var (
+	cfg    config.Config
+	store  database.Store
+	engine *search.Engine
+	mirror *images.Mirror
+
+	updateMutex  sync.Mutex
+	refreshMutex sync.Mutex
+)
 
 func main() {
 	cfg = config.InitConfig()
 
+	if err := godotenv.Load(); err != nil {
+		log.Print("No .env file found")
+	}
+	if err := words.LoadStopWords(""); err != nil {
+		log.Fatalf("Failed to load stop words: %v", err)
+	}
+	if err := words.LoadSynonyms(""); err != nil {
+		log.Fatalf("Failed to load synonyms: %v", err)
+	}
+
+	var err error
+	database.LockTimeout = cfg.LockTimeout
+	if err := search.CheckFieldBoosts(cfg.FieldBoosts); err != nil {
+		log.Fatalf("Invalid field_boosts: %v", err)
+	}
+	store, err = database.Open(cfg.DBDriver, cfg.DBFile)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+
+	if err := database.Upgrade(store, cfg.IndexFile); err != nil {
+		log.Fatalf("Failed to upgrade database: %v", err)
+	}
+
+	database.Revisions = database.NewRevisionLog(cfg.RevisionsFile)
+
+	if cfg.ImageDir != "" {
+		mirror = images.NewMirror(cfg.ImageDir, cfg.ImageCacheSize)
+		database.Images = mirror
+	}
+
+	engine, err = search.NewEngine(store, cfg.FieldBoosts)
+	if err != nil {
+		log.Fatalf("Failed to load search engine: %v", err)
+	}
+
 	go ScheduleDailyUpdates()
+	if cfg.RefreshInterval > 0 {
+		go ScheduleRefreshes(cfg.RefreshInterval)
+	}
+	if cfg.BackupInterval > 0 {
+		go ScheduleSnapshots(cfg.BackupInterval)
+	}
 
 	http.HandleFunc("/update", handleUpdate)
+	http.HandleFunc("/refresh", handleRefresh)
 	http.HandleFunc("/pics", handlePics)
+	http.HandleFunc("/suggest", handleSuggest)
+	http.HandleFunc("/images/", handleImage)
+	http.HandleFunc("/thumbs/", handleThumbnail)
+	http.HandleFunc("/similar-images/", handleSimilarImages)
+	http.HandleFunc("/revisions/", handleRevisions)
 	log.Printf("Server is starting on port %s", cfg.Port)
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
 }
@@ -30,21 +96,105 @@ func ScheduleDailyUpdates() {
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
 	for range ticker.C {
-		xkcdClient := xkcd.New(cfg.SourceURL)
-		if _, _, err := database.UpdateComics(cfg.DBFile, xkcdClient); err != nil {
+		if _, _, err := updateComics(); err != nil {
 			log.Printf("Error during scheduled update: %v", err)
 		}
 	}
 }
 
-func handleUpdate(w http.ResponseWriter, r *http.Request) {
+// ScheduleRefreshes fetches a batch of stored comics again every interval to pick up upstream fixes.
+func ScheduleRefreshes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := refreshComics()
+		if err != nil {
+			log.Printf("Error during scheduled refresh: %v", err)
+			continue
+		}
+		log.Printf("Refreshed %d comics: %d changed, %d failed", result.Checked, result.Changed, result.Failed)
+	}
+}
+
+// refreshComics runs one refresh batch and swaps changed comics into the search engine.
+func refreshComics() (database.RefreshResult, error) {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+
+	xkcdClient := xkcd.New(cfg.SourceURL)
+	result, err := database.RefreshComics(store, cfg.IndexFile, xkcdClient, cfg.RefreshBatch, cfg.RefreshDelay())
+	if err != nil {
+		return result, err
+	}
+	if result.Changed > 0 {
+		if err := engine.Reload(store); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	result, err := refreshComics()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error refreshing comics: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]int{"checked": result.Checked, "changed": result.Changed, "failed": result.Failed}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ScheduleSnapshots takes a snapshot every interval and drops the ones beyond the retention.
+func ScheduleSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		path, err := database.Snapshot(store, cfg.IndexFile, cfg.BackupDir)
+		if err != nil {
+			log.Printf("Error during scheduled snapshot: %v", err)
+			continue
+		}
+		log.Printf("Snapshot written to %s", path)
+		if _, err := database.PruneSnapshots(cfg.BackupDir, cfg.BackupKeep); err != nil {
+			log.Printf("Error pruning snapshots: %v", err)
+		}
+	}
+}
+
+// updateComics fetches new comics, commits them together with the index and
+// swaps the refreshed data into the search engine.
+func updateComics() (int, int, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
 	xkcdClient := xkcd.New(cfg.SourceURL)
-	newComics, totalComics, err := database.UpdateComics(cfg.DBFile, xkcdClient)
+	newComics, totalComics, err := database.UpdateComics(store, cfg.IndexFile, xkcdClient)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := database.MaybeFlushComicData(store, cfg.IndexFile); err != nil {
+		return 0, 0, err
+	}
+	if err := engine.Reload(store); err != nil {
+		return 0, 0, err
+	}
+	return newComics, totalComics, nil
+}
+
+func handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	newComics, totalComics, err := updateComics()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error updating database: %v", err), http.StatusInternalServerError)
 		return
@@ -56,6 +206,25 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// picsResponse is the response of /pics?details=1. Hits hold the score of
+// each of Pics and what every field contributed to it. Suggestion is a
+// spelling correction of a query that found nothing.
+type picsResponse struct {
+	Pics       []string `json:"pics"`
+	Hits       []picHit `json:"hits"`
+	Suggestion string   `json:"suggestion,omitempty"`
+}
+
+type picHit struct {
+	Num    int                `json:"num"`
+	Score  float64            `json:"score"`
+	Fields map[string]float64 `json:"fields,omitempty"`
+}
+
+// handlePics responds with the images of the comics matching the query at
+// /pics?search=, the best first. A spelling correction of a query that found
+// nothing is sent in the X-Suggestion header. With details=1 the response is
+// a picsResponse instead of the list of images.
 func handlePics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
@@ -67,23 +236,316 @@ func handlePics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	index, err := words.LoadIndex(cfg.IndexFile)
+	// With a width, list views get links to thumbnails instead of the full images.
+	var width int
+	if r.URL.Query().Has("w") {
+		var err error
+		if width, err = thumbnailWidth(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fuzzy, err := words.ParseFuzziness(r.URL.Query().Get("fuzzy"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error loading index: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Parameter 'fuzzy': %v", err), http.StatusBadRequest)
 		return
 	}
-	ids := words.SearchIndex(query, index)
 
-	pics := make([]string, 0)
-	for _, id := range ids {
-		comic, err := database.GetComicByID(cfg.DBFile, id)
-		if err != nil {
-			log.Printf("Failed to get comic by ID %d: %v", id, err)
-			continue
+	var results []search.Hit
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		at, timeErr := search.ParseTime(value)
+		if timeErr != nil {
+			http.Error(w, "Parameter 'as_of' must be a date or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		results, err = search.SearchAsOf(store, database.Revisions, query, at, fuzzy, cfg.FieldBoosts)
+	} else {
+		results, err = engine.Search(query, fuzzy)
+	}
+	var syntaxErr *search.ParseError
+	if errors.As(err, &syntaxErr) {
+		http.Error(w, fmt.Sprintf("Invalid search query: %v", err), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := picsResponse{Pics: make([]string, 0), Hits: make([]picHit, 0)}
+	for _, hit := range results {
+		comic := hit.Comic
+		response.Hits = append(response.Hits, picHit{Num: comic.Num, Score: hit.Score, Fields: hit.Fields})
+		if width > 0 {
+			response.Pics = append(response.Pics, fmt.Sprintf("/thumbs/%d?w=%d", comic.Num, width))
+		} else {
+			response.Pics = append(response.Pics, comic.Img)
+		}
+	}
+	if len(results) == 0 && r.URL.Query().Get("as_of") == "" {
+		response.Suggestion = engine.DidYouMean(query, fuzzy)
+		if response.Suggestion != "" {
+			w.Header().Set("X-Suggestion", response.Suggestion)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("details") == "1" {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	json.NewEncoder(w).Encode(response.Pics)
+}
+
+// suggestion is a completion in the response of /suggest.
+type suggestion struct {
+	Text string `json:"text"`
+	Docs int    `json:"docs"`
+}
+
+// handleSuggest completes the last word of a partly typed query at
+// /suggest?q=, the words found in the most comics first.
+func handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, err := intParam(r, "limit", words.MaxSuggestions)
+	if err != nil || limit <= 0 || limit > words.MaxSuggestions {
+		http.Error(w, fmt.Sprintf("Parameter 'limit' must be between 1 and %d", words.MaxSuggestions), http.StatusBadRequest)
+		return
+	}
+
+	suggestions := make([]suggestion, 0, limit)
+	for _, s := range engine.Suggest(r.URL.Query().Get("q"), limit) {
+		suggestions = append(suggestions, suggestion{Text: s.Text, Docs: s.Docs})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// revisionSummary is a revision in the response of /revisions/{num}.
+type revisionSummary struct {
+	Revision  int       `json:"revision"`
+	Fetched   time.Time `json:"fetched"`
+	Estimated bool      `json:"estimated,omitempty"`
+	Hash      string    `json:"hash"`
+	Title     string    `json:"title,omitempty"`
+}
+
+// handleRevisions lists the revisions of a comic at /revisions/{num} and
+// shows the changes between two of them at /revisions/{num}/diff?from=&to=,
+// which compares the last two by default.
+func handleRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/revisions/")
+	path, isDiff := strings.CutSuffix(path, "/diff")
+	num, err := strconv.Atoi(path)
+	if err != nil {
+		http.Error(w, "Comic number is required", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := database.Revisions.List(num)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading revisions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(revisions) == 0 {
+		http.Error(w, fmt.Sprintf("Comic %d has no revisions", num), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !isDiff {
+		summaries := make([]revisionSummary, 0, len(revisions))
+		for i, revision := range revisions {
+			summaries = append(summaries, revisionSummary{
+				Revision:  i + 1,
+				Fetched:   revision.Fetched,
+				Estimated: revision.Estimated,
+				Hash:      revision.Hash,
+				Title:     revision.Comic.Title,
+			})
 		}
-		pics = append(pics, comic.Img)
+		json.NewEncoder(w).Encode(summaries)
+		return
+	}
+
+	defaultFrom := len(revisions) - 1
+	if defaultFrom < 1 {
+		defaultFrom = 1
+	}
+	from, err := intParam(r, "from", defaultFrom)
+	if err != nil {
+		http.Error(w, "Parameter 'from' must be a number", http.StatusBadRequest)
+		return
+	}
+	to, err := intParam(r, "to", len(revisions))
+	if err != nil {
+		http.Error(w, "Parameter 'to' must be a number", http.StatusBadRequest)
+		return
+	}
+	if from < 1 || from > len(revisions) || to < 1 || to > len(revisions) {
+		http.Error(w, fmt.Sprintf("Revisions of comic %d are numbered 1 to %d", num, len(revisions)), http.StatusBadRequest)
+		return
 	}
+	changes := database.DiffRevisions(revisions[from-1], revisions[to-1])
+	if changes == nil {
+		changes = []database.FieldDiff{}
+	}
+	json.NewEncoder(w).Encode(changes)
+}
+
+// similarImage is a comic in the response of /similar-images.
+type similarImage struct {
+	Num      int    `json:"num"`
+	Distance int    `json:"distance"`
+	Title    string `json:"title,omitempty"`
+	Img      string `json:"img"`
+}
 
+func handleSimilarImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	num, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/similar-images/"))
+	if err != nil {
+		http.Error(w, "Comic number is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", search.DefaultSimilarLimit)
+	if err != nil || limit <= 0 {
+		http.Error(w, "Parameter 'limit' must be a positive number", http.StatusBadRequest)
+		return
+	}
+	maxDistance, err := intParam(r, "distance", search.DefaultSimilarDistance)
+	if err != nil || maxDistance < 0 || maxDistance > 64 {
+		http.Error(w, "Parameter 'distance' must be between 0 and 64", http.StatusBadRequest)
+		return
+	}
+
+	snapshot := engine.Snapshot()
+	matches, err := search.SimilarImages(snapshot.Comics, snapshot.Images, num, limit, maxDistance)
+	switch {
+	case errors.Is(err, database.ErrComicNotFound):
+		http.Error(w, fmt.Sprintf("Comic %d not found", num), http.StatusNotFound)
+		return
+	case errors.Is(err, search.ErrNoImageHash):
+		http.Error(w, fmt.Sprintf("Image of comic %d has not been mirrored yet", num), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Error searching similar images: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	similar := make([]similarImage, 0, len(matches))
+	for _, match := range matches {
+		comic := snapshot.Comics[match.Num]
+		similar = append(similar, similarImage{Num: match.Num, Distance: match.Distance, Title: comic.Title, Img: comic.Img})
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(pics)
+	json.NewEncoder(w).Encode(similar)
+}
+
+// intParam returns the query parameter name as a number, or def if it is not given.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// handleImage serves the mirrored image of a comic. Images are mirrored by
+// updates, and the refresh crawl fills in the ones that were evicted.
+func handleImage(w http.ResponseWriter, r *http.Request) {
+	serveImage(w, r, "/images/", func(hash string) (*os.File, error) {
+		return mirror.Open(hash)
+	})
+}
+
+// handleThumbnail serves a thumbnail of the image of a comic at one of the configured widths.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	width, err := thumbnailWidth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serveImage(w, r, "/thumbs/", func(hash string) (*os.File, error) {
+		return mirror.Thumbnail(hash, width)
+	})
+}
+
+// thumbnailWidth returns the width asked for by the w parameter, or the
+// first configured width if there is none.
+func thumbnailWidth(r *http.Request) (int, error) {
+	if len(cfg.ThumbnailWidths) == 0 {
+		return 0, errors.New("thumbnails are disabled")
+	}
+	value := r.URL.Query().Get("w")
+	if value == "" {
+		return cfg.ThumbnailWidths[0], nil
+	}
+	width, err := strconv.Atoi(value)
+	if err == nil {
+		for _, allowed := range cfg.ThumbnailWidths {
+			if width == allowed {
+				return width, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Parameter 'w' must be one of %v", cfg.ThumbnailWidths)
+}
+
+// serveImage serves the file open returns for the image of the comic named
+// by the path after prefix.
+func serveImage(w http.ResponseWriter, r *http.Request, prefix string, open func(hash string) (*os.File, error)) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	num, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil {
+		http.Error(w, "Comic number is required", http.StatusBadRequest)
+		return
+	}
+	if mirror == nil {
+		http.Error(w, "Image mirror is disabled", http.StatusNotFound)
+		return
+	}
+
+	comic, err := store.Get(num)
+	if errors.Is(err, database.ErrComicNotFound) {
+		http.Error(w, fmt.Sprintf("Comic %d not found", num), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading comic: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := open(comic.ImageHash)
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("Image of comic %d has not been mirrored yet", num), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening image: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// The content never changes for a path in the mirror, so its name makes
+	// a strong ETag. The modification time of the file is its last use, so it
+	// is not sent.
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening image: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", `"`+info.Name()+`"`)
+	http.ServeContent(w, r, "", time.Time{}, file)
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/config"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/search"
+)
+
+// runSnapshot writes a snapshot to the backup directory and returns the exit code.
+func runSnapshot(args []string, cfg config.Config) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	dir := flags.String("dir", cfg.BackupDir, "Directory to write the snapshot to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	path, err := database.Snapshot(store, indexFile, *dir)
+	if err != nil {
+		log.Printf("Failed to take snapshot: %v", err)
+		return 1
+	}
+	fmt.Printf("Snapshot written to %s.\n", path)
+
+	removed, err := database.PruneSnapshots(*dir, cfg.BackupKeep)
+	if err != nil {
+		log.Printf("Failed to prune snapshots: %v", err)
+		return 1
+	}
+	for _, snapshot := range removed {
+		fmt.Printf("Removed old snapshot %s.\n", snapshot)
+	}
+	return 0
+}
+
+// runRestore replaces the database and the index with a snapshot and returns the exit code.
+func runRestore(args []string, cfg config.Config) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := flags.String("dir", cfg.BackupDir, "Directory to look for snapshots in")
+	at := flags.String("at", "", "Restore the newest snapshot taken at or before this time (RFC 3339 or YYYY-MM-DD)")
+	check := flags.Bool("check", false, "Only verify the checksums of the snapshot")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var archive string
+	switch {
+	case flags.NArg() == 1 && *at == "":
+		archive = flags.Arg(0)
+	case flags.NArg() == 0 && *at != "":
+		when, err := search.ParseTime(*at)
+		if err != nil {
+			log.Printf("Invalid time %q: %v", *at, err)
+			return 2
+		}
+		if archive, err = database.FindSnapshot(*dir, when); err != nil {
+			log.Printf("Failed to find snapshot: %v", err)
+			return 1
+		}
+	default:
+		fmt.Println("usage: xkcd [flags] restore [-check] [-dir dir] archive | -at time")
+		return 2
+	}
+
+	if *check {
+		info, _, err := database.ReadSnapshot(archive)
+		if err != nil {
+			log.Printf("Snapshot %s is damaged: %v", archive, err)
+			return 1
+		}
+		fmt.Printf("Snapshot %s taken %s is intact.\n", archive, info.Created.Format(time.RFC3339))
+		return 0
+	}
+
+	if err := database.Restore(archive, cfg.DBDriver, cfg.DBFile, cfg.IndexFile); err != nil {
+		log.Printf("Failed to restore %s: %v", archive, err)
+		return 1
+	}
+	fmt.Printf("Restored %s.\n", archive)
+	return 0
+}
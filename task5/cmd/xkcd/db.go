@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+)
+
+const dbUsage = "usage: xkcd [flags] db verify|repair|compact|stats"
+
+// runDBCommand runs a database maintenance subcommand and returns the exit code.
+func runDBCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Println(dbUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "stats":
+		report, err := database.Inspect(store, indexFile)
+		if err != nil {
+			log.Printf("Failed to inspect database: %v", err)
+			return 1
+		}
+		printStats(report)
+		return 0
+
+	case "verify":
+		report, err := database.Inspect(store, indexFile)
+		if err != nil {
+			log.Printf("Failed to inspect database: %v", err)
+			return 1
+		}
+		printProblems(report)
+		if report.Problems() > 0 {
+			return 1
+		}
+		return 0
+
+	case "repair":
+		before, err := database.Inspect(store, indexFile)
+		if err != nil {
+			log.Printf("Failed to inspect database: %v", err)
+			return 1
+		}
+		if err := database.Repair(store, indexFile); err != nil {
+			log.Printf("Failed to repair database: %v", err)
+			return 1
+		}
+		after, err := database.Inspect(store, indexFile)
+		if err != nil {
+			log.Printf("Failed to inspect database: %v", err)
+			return 1
+		}
+		fmt.Printf("Removed %d duplicate records, reindexed %d missing, %d orphaned and %d stale comics.\n",
+			before.Duplicates-after.Duplicates, len(before.MissingFromIndex), len(before.Orphaned), len(before.Stale))
+		printProblems(after)
+		return 0
+
+	case "compact":
+		before, err := database.Inspect(store, indexFile)
+		if err != nil {
+			log.Printf("Failed to inspect database: %v", err)
+			return 1
+		}
+		if err := database.Compact(store, indexFile); err != nil {
+			log.Printf("Failed to compact database: %v", err)
+			return 1
+		}
+		fmt.Printf("Compacted %d records into %d comics.\n", before.Records, before.Comics)
+		return 0
+
+	default:
+		fmt.Println(dbUsage)
+		return 2
+	}
+}
+
+func printStats(report database.Report) {
+	fmt.Printf("Comics: %d\n", report.Comics)
+	fmt.Printf("Records: %d (%d duplicates)\n", report.Records, report.Duplicates)
+	fmt.Printf("Last comic: %d\n", report.LastNum)
+	fmt.Printf("Gaps: %d %s\n", len(report.Gaps), formatNums(report.Gaps))
+	fmt.Printf("Index terms: %d, postings: %d\n", report.Terms, report.Postings)
+	fmt.Printf("Schema version: %d, analyzer version: %d, index analyzer version: %d, index format: %d\n",
+		report.Manifest.SchemaVersion, report.Manifest.AnalyzerVersion, report.Manifest.IndexAnalyzerVersion,
+		report.Manifest.IndexFormat)
+	fmt.Printf("Generation: %d, consistent: %t\n", report.Manifest.Generation, report.Consistent)
+}
+
+func printProblems(report database.Report) {
+	if report.Problems() == 0 {
+		fmt.Printf("OK: %d comics, index matches generation %d.\n", report.Comics, report.Manifest.Generation)
+		return
+	}
+	fmt.Printf("Duplicate records: %d\n", report.Duplicates)
+	fmt.Printf("Comics missing from index: %d %s\n", len(report.MissingFromIndex), formatNums(report.MissingFromIndex))
+	fmt.Printf("Index entries without comic: %d %s\n", len(report.Orphaned), formatNums(report.Orphaned))
+	fmt.Printf("Stale index entries: %d %s\n", len(report.Stale), formatNums(report.Stale))
+	if !report.Consistent {
+		fmt.Printf("Database and index do not match manifest generation %d.\n", report.Manifest.Generation)
+	}
+}
+
+// formatNums lists the first comic numbers of nums.
+func formatNums(nums []int) string {
+	const limit = 20
+	if len(nums) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, limit)
+	for i, num := range nums {
+		if i == limit {
+			parts = append(parts, "...")
+			break
+		}
+		parts = append(parts, fmt.Sprint(num))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
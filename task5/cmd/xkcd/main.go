@@ -13,6 +13,7 @@ import (
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/config"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/search"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
@@ -23,18 +24,31 @@ import (
 )
 
 var (
-	dbFile      string
+	store       database.Store
 	indexFile   string
 	searchQuery string
+	migrate     bool
+	convertTo   string
+	mirrorAll   bool
+	similarTo   int
+	asOf        string
+	fuzzy       string
+	refresh     int
 )
 
 var ErrNotFound = errors.New("comic not found")
 
 func main() {
-	var configPath, port string
+	var configPath string
 	flag.StringVar(&configPath, "c", "./config/config.yaml", "Path to config file")
 	flag.StringVar(&searchQuery, "s", "", "Search query for comics")
-	flag.StringVar(&port, "p", "", "Port (unused for this app)")
+	flag.StringVar(&fuzzy, "fuzzy", "auto", "Match misspelled search terms: auto, off or a number of edits")
+	flag.StringVar(&asOf, "as-of", "", "Search the comics as they were at this time (RFC 3339 or YYYY-MM-DD)")
+	flag.IntVar(&refresh, "refresh", 0, "Fetch this many stored comics again, continuing the rotation, and update the changed ones")
+	flag.BoolVar(&migrate, "migrate", false, "Fetch missing metadata of comics stored with an older schema")
+	flag.StringVar(&convertTo, "convert-index", "", "Convert the index to this file, binary if it ends with .bin")
+	flag.BoolVar(&mirrorAll, "mirror-images", false, "Download the images of stored comics missing from the image mirror")
+	flag.IntVar(&similarTo, "similar-images", 0, "Show the comics whose images look most like the image of this comic")
 	flag.Parse()
 
 	if err := godotenv.Load(); err != nil {
@@ -49,20 +63,133 @@ func main() {
 	if err := words.LoadStopWords(""); err != nil {
 		log.Fatalf("Failed to load stop words: %v", err)
 	}
+	if err := words.LoadSynonyms(""); err != nil {
+		log.Fatalf("Failed to load synonyms: %v", err)
+	}
 
 	config := config.InitConfig()
 	client := xkcd.New(viper.GetString("source_url"))
-	dbFile = viper.GetString("db_file")
-	indexFile = viper.GetString("index_file")
+	indexFile = config.IndexFile
 	downloadWorkers := config.Parallel
 	processWorkers := 2
 
+	var err error
+	database.LockTimeout = config.LockTimeout
+	if err := search.CheckFieldBoosts(config.FieldBoosts); err != nil {
+		log.Fatalf("Invalid field_boosts: %v", err)
+	}
+
+	// A restore replaces the database files, so it runs before they are opened.
+	if flag.Arg(0) == "restore" {
+		os.Exit(runRestore(flag.Args()[1:], config))
+	}
+
+	store, err = database.Open(config.DBDriver, config.DBFile)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+
+	// Maintenance commands look at the files as they are, before any upgrade.
+	switch flag.Arg(0) {
+	case "db":
+		code := runDBCommand(flag.Args()[1:])
+		store.Close()
+		os.Exit(code)
+	case "snapshot":
+		code := runSnapshot(flag.Args()[1:], config)
+		store.Close()
+		os.Exit(code)
+	}
+
+	if err := database.Upgrade(store, indexFile); err != nil {
+		log.Fatalf("Failed to upgrade database: %v", err)
+	}
+
+	database.Revisions = database.NewRevisionLog(config.RevisionsFile)
+
+	switch flag.Arg(0) {
+	case "export":
+		code := runExport(flag.Args()[1:])
+		store.Close()
+		os.Exit(code)
+	case "import":
+		code := runImport(flag.Args()[1:])
+		store.Close()
+		os.Exit(code)
+	case "revisions":
+		code := runRevisions(flag.Args()[1:])
+		store.Close()
+		os.Exit(code)
+	}
+
+	if convertTo != "" {
+		if err := words.ConvertIndex(indexFile, convertTo); err != nil {
+			log.Fatalf("Failed to convert index: %v", err)
+		}
+		fmt.Printf("Index converted to %s.\n", convertTo)
+		return
+	}
+
 	if searchQuery != "" {
-		search.HandleSearchQuery(dbFile, indexFile, searchQuery)
+		fuzziness, err := words.ParseFuzziness(fuzzy)
+		if err != nil {
+			log.Fatalf("Invalid fuzziness %q: %v", fuzzy, err)
+		}
+		if asOf != "" {
+			at, err := search.ParseTime(asOf)
+			if err != nil {
+				log.Fatalf("Invalid time %q: %v", asOf, err)
+			}
+			search.HandleSearchAsOf(store, database.Revisions, searchQuery, at, fuzziness, config.FieldBoosts)
+			return
+		}
+		search.HandleSearchQuery(store, indexFile, searchQuery, fuzziness, config.FieldBoosts)
 		return
 	}
 
-	lastComicNum, existingComics := database.GetLastComicNum(dbFile)
+	if similarTo != 0 {
+		search.HandleSimilarImages(store, similarTo)
+		return
+	}
+
+	var mirror *images.Mirror
+	if config.ImageDir != "" {
+		mirror = images.NewMirror(config.ImageDir, config.ImageCacheSize)
+		database.Images = mirror
+	}
+
+	if mirrorAll {
+		if mirror == nil {
+			log.Fatalf("Set image_dir in the config to mirror images")
+		}
+		mirrored, err := database.MirrorImages(store, indexFile, mirror)
+		if err != nil {
+			log.Fatalf("Failed to mirror images: %v", err)
+		}
+		fmt.Printf("Mirrored %d images.\n", mirrored)
+		return
+	}
+
+	if refresh > 0 {
+		result, err := database.RefreshComics(store, indexFile, client, refresh, config.RefreshDelay())
+		if err != nil {
+			log.Fatalf("Failed to refresh comics: %v", err)
+		}
+		fmt.Printf("Checked %d comics: %d changed, %d failed.\n", result.Checked, result.Changed, result.Failed)
+		return
+	}
+
+	if migrate {
+		migrated, remaining, err := database.MigrateComics(store, client)
+		if err != nil {
+			log.Fatalf("Failed to migrate comics: %v", err)
+		}
+		fmt.Printf("Migrated %d comics, %d left to migrate.\n", migrated, remaining)
+		return
+	}
+
+	lastComicNum, existingComics := database.GetLastComicNum(store)
 	var comicNum int64 = int64(lastComicNum)
 
 	comicsChan := make(chan *models.Comic, downloadWorkers)
@@ -93,14 +220,14 @@ func main() {
 				log.Printf("Failed to fetch missing comic %d: %v", i, err)
 				continue
 			}
-			if err := database.SaveComicData(*comic, dbFile); err != nil {
+			if err := database.SaveComicData(*comic, store, indexFile); err != nil {
 				log.Printf("Error saving comic %d: %v", comic.Num, err)
 			}
-			if err := database.BuildIndex(dbFile, config.IndexFile); err != nil {
-				log.Printf("Error building index: %v", err)
-			}
 		}
 	}
+	if err := database.MaybeFlushComicData(store, indexFile); err != nil {
+		log.Printf("Error saving comics: %v", err)
+	}
 
 	for i := 0; i < downloadWorkers; i++ {
 		downloadWg.Add(1)
@@ -135,7 +262,7 @@ func main() {
 		go func() {
 			defer processWg.Done()
 			for comic := range processedChan {
-				if err := database.SaveComicData(*comic, dbFile); err != nil {
+				if err := database.SaveComicData(*comic, store, indexFile); err != nil {
 					log.Printf("Error saving comic %d: %v", comic.Num, err)
 				}
 			}
@@ -165,5 +292,8 @@ func main() {
 	}
 
 	close(errsChan)
+	if err := database.MaybeFlushComicData(store, indexFile); err != nil {
+		log.Printf("Error saving comics: %v", err)
+	}
 	fmt.Println("All comics fetched and saved.")
 }
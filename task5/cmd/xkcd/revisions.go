@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+)
+
+// runRevisions lists the revisions of a comic or shows the difference
+// between two of them, and returns the exit code.
+func runRevisions(args []string) int {
+	flags := flag.NewFlagSet("revisions", flag.ContinueOnError)
+	diff := flags.String("diff", "", "Show the changes between two revisions, as from,to; 'last' compares the last two")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	num, err := strconv.Atoi(flags.Arg(0))
+	if flags.NArg() != 1 || err != nil {
+		fmt.Println("usage: xkcd [flags] revisions [-diff from,to|last] num")
+		return 2
+	}
+
+	revisions, err := database.Revisions.List(num)
+	if err != nil {
+		log.Printf("Failed to list revisions: %v", err)
+		return 1
+	}
+	if len(revisions) == 0 {
+		fmt.Printf("Comic %d has no revisions.\n", num)
+		return 1
+	}
+
+	if *diff == "" {
+		for i, revision := range revisions {
+			fetched := revision.Fetched.Format(time.RFC3339)
+			if revision.Fetched.IsZero() {
+				fetched = "unknown"
+			} else if revision.Estimated {
+				fetched += " (estimated)"
+			}
+			fmt.Printf("%d\t%s\t%s\n", i+1, fetched, revision.Hash[:12])
+		}
+		return 0
+	}
+
+	from, to, err := parseRevisionRange(*diff, len(revisions))
+	if err != nil {
+		log.Printf("Invalid revision range %q: %v", *diff, err)
+		return 2
+	}
+	changes := database.DiffRevisions(revisions[from-1], revisions[to-1])
+	if len(changes) == 0 {
+		fmt.Printf("Revisions %d and %d of comic %d are the same.\n", from, to, num)
+	}
+	for _, change := range changes {
+		fmt.Printf("%s:\n", change.Field)
+		if len(change.Lines) > 0 {
+			for _, line := range change.Lines {
+				fmt.Printf("  %s\n", line)
+			}
+			continue
+		}
+		fmt.Printf("  -%s\n  +%s\n", change.Old, change.New)
+	}
+	return 0
+}
+
+// parseRevisionRange parses a from,to pair of 1-based revision numbers.
+func parseRevisionRange(value string, count int) (int, int, error) {
+	if value == "last" {
+		if count < 2 {
+			return 0, 0, fmt.Errorf("there is only one revision")
+		}
+		return count - 1, count, nil
+	}
+	first, second, ok := strings.Cut(value, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected from,to")
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := strconv.Atoi(second)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from < 1 || from > count || to < 1 || to > count {
+		return 0, 0, fmt.Errorf("revisions are numbered 1 to %d", count)
+	}
+	return from, to, nil
+}
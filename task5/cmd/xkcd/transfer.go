@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+)
+
+// runExport writes the comic collection to a file or stdout and returns the exit code.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", database.FormatNDJSON, "Export format: ndjson or csv")
+	output := flags.String("o", "", "Output file, stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Printf("Failed to create %s: %v", *output, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	exported, err := database.Export(store, w, *format)
+	if err != nil {
+		log.Printf("Failed to export comics: %v", err)
+		return 1
+	}
+	if *output != "" {
+		fmt.Printf("Exported %d comics to %s.\n", exported, *output)
+	}
+	return 0
+}
+
+// runImport reads comics from a file or stdin into the database and returns the exit code.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", database.FormatNDJSON, "Import format: ndjson or csv")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Println("usage: xkcd [flags] import [-format ndjson|csv] file|-")
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to open %s: %v", path, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := database.Import(store, indexFile, r, *format)
+	for _, message := range result.Errors {
+		log.Printf("Skipped invalid %s", message)
+	}
+	if err != nil {
+		log.Printf("Failed to import comics: %v", err)
+		return 1
+	}
+	fmt.Printf("Imported %d comics, skipped %d duplicates and %d invalid records.\n",
+		result.Imported, result.Duplicates, result.Invalid)
+	return 0
+}
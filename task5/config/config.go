@@ -5,16 +5,49 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	SourceURL string `mapstructure:"source_url"`
+	DBDriver  string `mapstructure:"db_driver"`
 	DBFile    string `mapstructure:"db_file"`
 	IndexFile string `mapstructure:"index_file"`
 	Parallel  int    `mapstructure:"parallel"`
 	Port      string `mapstructure:"port"`
+
+	// LockTimeout is how long to wait for the database files to be unlocked
+	// by another process. Files are only locked on unix systems; elsewhere the
+	// CLI and the server must not use the same database at the same time.
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+
+	// RevisionsFile keeps every distinct version of the metadata of the comics.
+	RevisionsFile string `mapstructure:"revisions_file"`
+
+	// The server refreshes RefreshBatch stored comics every RefreshInterval if
+	// it is positive, fetching at most RefreshRate comics per second.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	RefreshBatch    int           `mapstructure:"refresh_batch"`
+	RefreshRate     float64       `mapstructure:"refresh_rate"`
+
+	// BackupDir is where snapshots are written. The server takes one every
+	// BackupInterval if it is positive, and only the newest BackupKeep are kept.
+	BackupDir      string        `mapstructure:"backup_dir"`
+	BackupInterval time.Duration `mapstructure:"backup_interval"`
+	BackupKeep     int           `mapstructure:"backup_keep"`
+
+	// ImageDir is where comic images are mirrored. Mirroring is off if it is
+	// empty. ImageCacheSize caps the mirror in bytes, zero means no cap.
+	ImageDir       string `mapstructure:"image_dir"`
+	ImageCacheSize int64  `mapstructure:"image_cache_size"`
+	// ThumbnailWidths are the widths thumbnails are served at; the first is the default.
+	ThumbnailWidths []int `mapstructure:"thumbnail_widths"`
+
+	// FieldBoosts weigh the matches of search words in the title, alt and
+	// transcript fields. Fields left out keep their default, see search.NewCorpus.
+	FieldBoosts map[string]float64 `mapstructure:"field_boosts"`
 }
 
 var portFlag string
@@ -31,9 +64,22 @@ func init() {
 
 	viper.SetConfigFile(configPath)
 	viper.SetDefault("source_url", "https://xkcd.com")
+	viper.SetDefault("db_driver", "json")
 	viper.SetDefault("db_file", "database.json")
 	viper.SetDefault("index_file", "index.json")
+	viper.SetDefault("revisions_file", "revisions.ndjson")
 	viper.SetDefault("parallel", runtime.NumCPU())
+	viper.SetDefault("lock_timeout", "30s")
+	viper.SetDefault("refresh_interval", "0s")
+	viper.SetDefault("refresh_batch", 100)
+	viper.SetDefault("refresh_rate", 1.0)
+	viper.SetDefault("backup_dir", "backups")
+	viper.SetDefault("backup_interval", "0s")
+	viper.SetDefault("backup_keep", 7)
+	viper.SetDefault("image_dir", "")
+	viper.SetDefault("image_cache_size", "0")
+	viper.SetDefault("thumbnail_widths", []int{150, 300, 600})
+	viper.SetDefault("field_boosts", map[string]float64{})
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Error reading config file, %s", err)
@@ -46,6 +92,14 @@ func init() {
 	}
 }
 
+// RefreshDelay is the least time between two fetches of a refresh.
+func (c Config) RefreshDelay() time.Duration {
+	if c.RefreshRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / c.RefreshRate)
+}
+
 func InitConfig() Config {
 	parallel := viper.GetInt("parallel")
 	if parallel <= 0 {
@@ -54,9 +108,37 @@ func InitConfig() Config {
 
 	return Config{
 		SourceURL: viper.GetString("source_url"),
+		DBDriver:  viper.GetString("db_driver"),
 		DBFile:    viper.GetString("db_file"),
 		IndexFile: viper.GetString("index_file"),
 		Parallel:  parallel,
 		Port:      viper.GetString("port"),
+
+		LockTimeout: viper.GetDuration("lock_timeout"),
+
+		RevisionsFile: viper.GetString("revisions_file"),
+
+		RefreshInterval: viper.GetDuration("refresh_interval"),
+		RefreshBatch:    viper.GetInt("refresh_batch"),
+		RefreshRate:     viper.GetFloat64("refresh_rate"),
+
+		BackupDir:      viper.GetString("backup_dir"),
+		BackupInterval: viper.GetDuration("backup_interval"),
+		BackupKeep:     viper.GetInt("backup_keep"),
+
+		ImageDir:       viper.GetString("image_dir"),
+		ImageCacheSize: int64(viper.GetSizeInBytes("image_cache_size")),
+
+		ThumbnailWidths: viper.GetIntSlice("thumbnail_widths"),
+
+		FieldBoosts: fieldBoosts(),
+	}
+}
+
+func fieldBoosts() map[string]float64 {
+	var boosts map[string]float64
+	if err := viper.UnmarshalKey("field_boosts", &boosts); err != nil {
+		log.Fatalf("Invalid field_boosts: %v", err)
 	}
+	return boosts
 }
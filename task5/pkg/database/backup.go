@@ -0,0 +1,396 @@
+package database
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+// A snapshot is a gzipped tar archive named after the time it was taken. It holds
+//
+//	SHA256SUMS     checksums of all other entries in sha256sum format
+//	snapshot.json  the SnapshotInfo
+//	db/...         the database files
+//	index/...      the index base file and its segments
+//	manifest.json  the manifest, if there was one
+const (
+	snapshotPrefix     = "snapshot-"
+	snapshotSuffix     = ".tar.gz"
+	snapshotTimeFormat = "20060102T150405.000Z"
+
+	checksumsEntry    = "SHA256SUMS"
+	snapshotInfoEntry = "snapshot.json"
+	manifestEntry     = "manifest.json"
+	dbEntryDir        = "db/"
+	indexEntryDir     = "index/"
+)
+
+// SnapshotInfo describes where the files of a snapshot came from.
+type SnapshotInfo struct {
+	Created    time.Time `json:"created"`
+	Driver     string    `json:"driver"`
+	DBFile     string    `json:"db_file"`
+	IndexFile  string    `json:"index_file"`
+	Generation uint64    `json:"generation"`
+}
+
+// Snapshot writes an archive of the database, the index and the manifest to
+// dir and returns its path. Commits are held off while the files are read,
+// so the archive is a consistent point in time.
+func Snapshot(store Store, indexFile, dir string) (string, error) {
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	info := SnapshotInfo{
+		Created:   time.Now().UTC(),
+		IndexFile: filepath.Base(indexFile),
+	}
+	var dbFiles map[string][]byte
+	switch s := store.(type) {
+	case *JSONStore:
+		info.Driver, info.DBFile = DriverJSON, filepath.Base(s.path)
+		dbFiles, err = s.SnapshotFiles()
+	case *SQLiteStore:
+		info.Driver, info.DBFile = DriverSQLite, filepath.Base(s.path)
+		dbFiles, err = s.SnapshotFiles()
+	default:
+		return "", fmt.Errorf("snapshots are not supported for %T", store)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read database entries: %v", err)
+	}
+
+	entries := make(map[string][]byte)
+	for name, data := range dbFiles {
+		entries[dbEntryDir+name] = data
+	}
+
+	indexPaths, err := indexFiles(indexFile)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range indexPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		entries[indexEntryDir+filepath.Base(path)] = data
+	}
+
+	if data, err := os.ReadFile(ManifestPath(indexFile)); err == nil {
+		entries[manifestEntry] = data
+		manifest, err := ReadManifest(ManifestPath(indexFile))
+		if err != nil {
+			return "", err
+		}
+		info.Generation = manifest.Generation
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	if entries[snapshotInfoEntry], err = json.MarshalIndent(info, "", "  "); err != nil {
+		return "", fmt.Errorf("failed to encode snapshot info: %v", err)
+	}
+
+	archive, err := encodeSnapshot(entries)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory %s: %v", dir, err)
+	}
+	path := filepath.Join(dir, snapshotPrefix+info.Created.Format(snapshotTimeFormat)+snapshotSuffix)
+	if err := files.WriteAtomic(path, archive); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ReadSnapshot reads an archive and verifies the checksums of all its entries.
+func ReadSnapshot(archive string) (SnapshotInfo, map[string][]byte, error) {
+	var info SnapshotInfo
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return info, nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return info, nil, fmt.Errorf("failed to read snapshot %s: %v", archive, err)
+	}
+	files := make(map[string][]byte)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, nil, fmt.Errorf("failed to read snapshot %s: %v", archive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return info, nil, fmt.Errorf("unexpected entry %s in snapshot", header.Name)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return info, nil, fmt.Errorf("failed to read %s from snapshot: %v", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+
+	sums, ok := files[checksumsEntry]
+	if !ok {
+		return info, nil, fmt.Errorf("snapshot %s has no %s", archive, checksumsEntry)
+	}
+	delete(files, checksumsEntry)
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return info, nil, fmt.Errorf("malformed line in %s: %q", checksumsEntry, scanner.Text())
+		}
+		data, ok := files[name]
+		if !ok {
+			return info, nil, fmt.Errorf("%s is listed in %s but missing from the snapshot", name, checksumsEntry)
+		}
+		if checksum(data) != sum {
+			return info, nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+		listed[name] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return info, nil, fmt.Errorf("%s has no checksum", name)
+		}
+	}
+
+	if err := json.Unmarshal(files[snapshotInfoEntry], &info); err != nil {
+		return info, nil, fmt.Errorf("failed to decode snapshot info: %v", err)
+	}
+	return info, files, nil
+}
+
+// Restore replaces the database, the index and the manifest with the files
+// of a snapshot. Nothing is touched unless every checksum matches. The store
+// must not be open, since an open SQLite database would not see the new file.
+func Restore(archive, driver, dbFile, indexFile string) error {
+	info, entries, err := ReadSnapshot(archive)
+	if err != nil {
+		return err
+	}
+	if driver == "" {
+		driver = DriverJSON
+	}
+	if info.Driver != driver {
+		return fmt.Errorf("snapshot holds a %s database, but the %s driver is configured", info.Driver, driver)
+	}
+
+	targets := make(map[string][]byte)
+	for name, data := range entries {
+		var path string
+		switch {
+		case name == snapshotInfoEntry:
+			continue
+		case name == manifestEntry:
+			path = ManifestPath(indexFile)
+		case strings.HasPrefix(name, dbEntryDir):
+			path, err = restorePath(strings.TrimPrefix(name, dbEntryDir), info.DBFile, dbFile)
+		case strings.HasPrefix(name, indexEntryDir):
+			path, err = restorePath(strings.TrimPrefix(name, indexEntryDir), info.IndexFile, indexFile)
+		default:
+			err = fmt.Errorf("unexpected entry %s in snapshot", name)
+		}
+		if err != nil {
+			return err
+		}
+		targets[path] = data
+	}
+
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if driver == DriverJSON {
+		storeLock, err := LockExclusive(NewJSONStore(dbFile).lockPath())
+		if err != nil {
+			return err
+		}
+		defer storeLock.Unlock()
+	}
+
+	live, err := liveFiles(driver, dbFile, indexFile)
+	if err != nil {
+		return err
+	}
+	for path, data := range targets {
+		if err := files.WriteAtomic(path, data); err != nil {
+			return err
+		}
+	}
+	// Segments the snapshot does not have would be replayed over its files.
+	for _, path := range live {
+		if _, ok := targets[path]; ok {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// FindSnapshot returns the newest snapshot in dir taken at or before at.
+func FindSnapshot(dir string, at time.Time) (string, error) {
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return "", err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if created, ok := snapshotTime(snapshots[i]); ok && !created.After(at) {
+			return snapshots[i], nil
+		}
+	}
+	return "", fmt.Errorf("no snapshot in %s taken before %s", dir, at.Format(time.RFC3339))
+}
+
+// ListSnapshots returns the snapshots in dir from the oldest to the newest.
+func ListSnapshots(dir string) ([]string, error) {
+	snapshots, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+snapshotSuffix))
+	if err != nil {
+		return nil, err
+	}
+	// The timestamp format sorts by name.
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// PruneSnapshots removes all but the newest keep snapshots in dir and returns
+// the removed ones. A keep of zero or less keeps all of them.
+func PruneSnapshots(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil || len(snapshots) <= keep {
+		return nil, err
+	}
+
+	removed := snapshots[:len(snapshots)-keep]
+	for _, snapshot := range removed {
+		if err := os.Remove(snapshot); err != nil {
+			return nil, fmt.Errorf("failed to remove snapshot %s: %v", snapshot, err)
+		}
+	}
+	return removed, nil
+}
+
+func snapshotTime(path string) (time.Time, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), snapshotPrefix), snapshotSuffix)
+	created, err := time.Parse(snapshotTimeFormat, name)
+	return created, err == nil
+}
+
+func encodeSnapshot(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sums bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&sums, "%s  %s\n", checksum(files[name]), name)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write %s to snapshot: %v", name, err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s to snapshot: %v", name, err)
+		}
+		return nil
+	}
+	if err := add(checksumsEntry, sums.Bytes()); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := add(name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish snapshot: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish snapshot: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// restorePath maps a file of the snapshot, named after the snapshotted base
+// file, to the same file next to the configured one.
+func restorePath(name, snapshotted, configured string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, snapshotted) {
+		return "", fmt.Errorf("unexpected file %s in snapshot", name)
+	}
+	return configured + strings.TrimPrefix(name, snapshotted), nil
+}
+
+// indexFiles returns the index base file, if it exists, and its segments.
+func indexFiles(indexFile string) ([]string, error) {
+	segments, err := filepath.Glob(indexFile + ".*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(indexFile); err == nil {
+		return append([]string{indexFile}, segments...), nil
+	}
+	return segments, nil
+}
+
+// liveFiles returns the files that make up the current database and index.
+func liveFiles(driver, dbFile, indexFile string) ([]string, error) {
+	files, err := indexFiles(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, ManifestPath(indexFile), dbFile)
+	if driver == DriverSQLite {
+		return append(files, dbFile+"-journal", dbFile+"-wal", dbFile+"-shm"), nil
+	}
+	segments, err := NewJSONStore(dbFile).segments()
+	if err != nil {
+		return nil, err
+	}
+	return append(files, segments...), nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	for _, tc := range []struct{ driver, index string }{
+		{DriverJSON, "index.json"},
+		{DriverSQLite, "index.json"},
+		{DriverJSON, "index.bin"},
+	} {
+		driver := tc.driver
+		t.Run(driver+"/"+tc.index, func(t *testing.T) {
+			dir := t.TempDir()
+			dbFile := filepath.Join(dir, "database."+driver)
+			indexFile := filepath.Join(dir, tc.index)
+			backupDir := filepath.Join(dir, "backups")
+
+			store, err := Open(driver, dbFile)
+			if err != nil {
+				t.Fatalf("Failed to open store: %v", err)
+			}
+			if err := commitComics(store, indexFile, []ComicKeywords{{Num: 1, Keywords: []string{"barrel"}}}); err != nil {
+				t.Fatalf("Failed to commit comics: %v", err)
+			}
+			if err := commitComics(store, indexFile, []ComicKeywords{{Num: 2, Keywords: []string{"petit"}}}); err != nil {
+				t.Fatalf("Failed to commit comics: %v", err)
+			}
+			archive, err := Snapshot(store, indexFile, backupDir)
+			if err != nil {
+				t.Fatalf("Failed to take snapshot: %v", err)
+			}
+			manifest, err := ReadManifest(ManifestPath(indexFile))
+			if err != nil {
+				t.Fatalf("Failed to read manifest: %v", err)
+			}
+			info, _, err := ReadSnapshot(archive)
+			if err != nil {
+				t.Fatalf("Failed to read snapshot: %v", err)
+			}
+			if manifest.Generation != 2 || info.Generation != manifest.Generation {
+				t.Errorf("Expected the snapshot of generation 2, got %d with manifest generation %d", info.Generation, manifest.Generation)
+			}
+
+			if err := commitComics(store, indexFile, []ComicKeywords{{Num: 3, Keywords: []string{"island"}}}); err != nil {
+				t.Fatalf("Failed to commit comics: %v", err)
+			}
+			store.Close()
+
+			if err := Restore(archive, driver, dbFile, indexFile); err != nil {
+				t.Fatalf("Failed to restore: %v", err)
+			}
+			store, err = Open(driver, dbFile)
+			if err != nil {
+				t.Fatalf("Failed to reopen store: %v", err)
+			}
+			defer store.Close()
+
+			comics, err := store.All()
+			if err != nil {
+				t.Fatalf("Failed to load comics: %v", err)
+			}
+			if len(comics) != 2 || comics[3] != nil {
+				t.Errorf("Expected comics 1 and 2 after restore, got %d comics", len(comics))
+			}
+			if ok, err := Verify(store, indexFile); !ok || err != nil {
+				t.Errorf("Expected restored files to verify, got %v, %v", ok, err)
+			}
+			index, err := LoadIndex(indexFile)
+			if err != nil {
+				t.Fatalf("Failed to load index: %v", err)
+			}
+			if len(index.Terms["island"]) != 0 || len(index.Terms["petit"]) != 1 {
+				t.Errorf("Unexpected restored index: %v", index)
+			}
+		})
+	}
+}
+
+func TestRestoreRejectsDamagedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "database.json")
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(dbFile)
+	if err := commitComics(store, indexFile, []ComicKeywords{{Num: 1, Keywords: []string{"barrel"}}}); err != nil {
+		t.Fatalf("Failed to commit comics: %v", err)
+	}
+	archive, err := Snapshot(store, indexFile, dir)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	// Rewrite the archive with one byte of the database changed.
+	files := readArchive(t, archive)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	for name := range files {
+		if strings.HasPrefix(name, dbEntryDir) {
+			files[name] = bytes.Replace(files[name], []byte("barrel"), []byte("barrek"), 1)
+		}
+		writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		writer.Write(files[name])
+	}
+	writer.Close()
+	gz.Close()
+	damaged := filepath.Join(dir, "damaged.tar.gz")
+	if err := os.WriteFile(damaged, buf.Bytes(), 0666); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	before, _ := os.ReadFile(dbFile)
+	err = Restore(damaged, DriverJSON, dbFile, indexFile)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Expected a checksum mismatch, got %v", err)
+	}
+	if after, _ := os.ReadFile(dbFile); !bytes.Equal(before, after) {
+		t.Errorf("Database was changed by a failed restore")
+	}
+	if err := Restore(archive, DriverSQLite, dbFile, indexFile); err == nil {
+		t.Errorf("Expected restoring into another driver to fail")
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day < 5; day++ {
+		name := snapshotPrefix + start.AddDate(0, 0, day).Format(snapshotTimeFormat) + snapshotSuffix
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+	}
+
+	found, err := FindSnapshot(dir, start.AddDate(0, 0, 2).Add(time.Hour))
+	if err != nil || !strings.Contains(found, "20240103T") {
+		t.Errorf("Expected the snapshot of January 3rd, got %q, %v", found, err)
+	}
+	if _, err := FindSnapshot(dir, start.Add(-time.Hour)); err == nil {
+		t.Errorf("Expected no snapshot before the first one")
+	}
+
+	removed, err := PruneSnapshots(dir, 2)
+	if err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	remaining, _ := ListSnapshots(dir)
+	if len(removed) != 3 || len(remaining) != 2 || !strings.Contains(remaining[0], "20240104T") {
+		t.Errorf("Expected the two newest snapshots to remain, got %v", remaining)
+	}
+}
+
+func readArchive(t *testing.T, archive string) map[string][]byte {
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		files[header.Name], _ = io.ReadAll(reader)
+	}
+}
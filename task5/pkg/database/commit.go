@@ -0,0 +1,247 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+var (
+	indexWriters      = make(map[string]*words.IndexWriter)
+	indexWritersMutex sync.Mutex
+)
+
+// indexWriter returns the shared writer of the index file.
+func indexWriter(indexFile string) *words.IndexWriter {
+	indexWritersMutex.Lock()
+	defer indexWritersMutex.Unlock()
+
+	writer, ok := indexWriters[indexFile]
+	if !ok {
+		writer = words.NewIndexWriter(indexFile)
+		writer.Lock = func() (func(), error) {
+			lock, err := LockExclusive(commitLockPath(indexFile))
+			if err != nil {
+				return nil, err
+			}
+			return func() { lock.Unlock() }, nil
+		}
+		indexWriters[indexFile] = writer
+	}
+	return writer
+}
+
+// commitComics saves comics and appends their postings to the index under a
+// new generation. The database checksum in the manifest is updated
+// incrementally, so the cost only depends on the number of saved comics.
+// Without a committed generation to build on the whole index is rebuilt.
+func commitComics(store Store, indexFile string, comics []ComicKeywords) error {
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	manifestFile := ManifestPath(indexFile)
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(indexFile); manifest.Generation == 0 || err != nil {
+		if err := store.Save(comics); err != nil {
+			return err
+		}
+		return buildIndex(store, indexFile)
+	}
+
+	dbChecksum, err := hex.DecodeString(manifest.DBChecksum)
+	if err != nil || len(dbChecksum) != sha256.Size {
+		return fmt.Errorf("invalid database checksum in manifest %s", manifestFile)
+	}
+	// Only the last record of a comic is stored, so only it may enter the checksum.
+	comics = lastRecords(comics)
+	for _, comic := range comics {
+		if old, err := store.Get(comic.Num); err == nil {
+			xorDocument(dbChecksum, old.Num, old.Keywords)
+		} else if !errors.Is(err, ErrComicNotFound) {
+			return err
+		}
+	}
+	if err := store.Save(comics); err != nil {
+		return err
+	}
+
+	writer := indexWriter(indexFile)
+	for _, comic := range comics {
+		writer.Add(comic.Num, comic.Keywords, comicForms(&comic), comicFields(&comic))
+		xorDocument(dbChecksum, comic.Num, comic.Keywords)
+	}
+	if err := writer.Commit(manifest.Generation + 1); err != nil {
+		return err
+	}
+
+	manifest.Generation++
+	manifest.DBChecksum = hex.EncodeToString(dbChecksum)
+	if err := WriteManifest(manifestFile, manifest); err != nil {
+		return err
+	}
+	writer.MergeInBackground()
+	return nil
+}
+
+// lastRecords returns the last record of every comic of a batch, in the
+// order of the records.
+func lastRecords(comics []ComicKeywords) []ComicKeywords {
+	last := make(map[int]int, len(comics))
+	for i, comic := range comics {
+		last[comic.Num] = i
+	}
+	if len(last) == len(comics) {
+		return comics
+	}
+	records := make([]ComicKeywords, 0, len(last))
+	for i, comic := range comics {
+		if last[comic.Num] == i {
+			records = append(records, comic)
+		}
+	}
+	return records
+}
+
+// commitIndex replaces the whole index with the index of comics and then
+// switches the manifest to a new generation recording the checksum of the
+// database contents. Until the manifest is switched the previous generation
+// stays current, so a crash at any point leaves a state that Verify detects.
+func commitIndex(comics map[int]*ComicKeywords, indexFile string) error {
+	if err := indexWriter(indexFile).Replace(MakeIndex(comics)); err != nil {
+		return fmt.Errorf("failed to write index file: %v", err)
+	}
+	if err := files.SyncDir(filepath.Dir(indexFile)); err != nil {
+		return err
+	}
+
+	manifestFile := ManifestPath(indexFile)
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		return err
+	}
+	manifest.IndexAnalyzerVersion = words.AnalyzerVersion
+	manifest.IndexFormat = words.IndexFormat
+	manifest.Generation++
+	manifest.DBChecksum = DBChecksum(comics)
+	return WriteManifest(manifestFile, manifest)
+}
+
+// Recover drops index changes of a commit that never switched the manifest.
+func Recover(indexFile string) error {
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return recoverIndex(indexFile)
+}
+
+func recoverIndex(indexFile string) error {
+	manifest, err := ReadManifest(ManifestPath(indexFile))
+	if err != nil {
+		return err
+	}
+	return indexWriter(indexFile).Rollback(manifest.Generation)
+}
+
+// Verify reports whether the database and the index on disk both match the
+// generation recorded in the manifest.
+func Verify(store Store, indexFile string) (bool, error) {
+	lock, err := LockShared(commitLockPath(indexFile))
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	return verify(store, indexFile)
+}
+
+func verify(store Store, indexFile string) (bool, error) {
+	manifest, err := ReadManifest(ManifestPath(indexFile))
+	if err != nil {
+		return false, err
+	}
+	if manifest.Generation == 0 {
+		return false, nil
+	}
+
+	comics, err := store.All()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %v", err)
+	}
+	if DBChecksum(comics) != manifest.DBChecksum {
+		return false, nil
+	}
+
+	if _, err := os.Stat(indexFile); os.IsNotExist(err) {
+		return false, nil
+	}
+	index, err := words.LoadIndex(indexFile)
+	if err != nil {
+		return false, err
+	}
+	return IndexChecksum(index) == manifest.DBChecksum, nil
+}
+
+// LoadIndex loads the index while no other process is committing to it.
+func LoadIndex(indexFile string) (words.Index, error) {
+	lock, err := LockShared(commitLockPath(indexFile))
+	if err != nil {
+		return words.Index{}, err
+	}
+	defer lock.Unlock()
+
+	return words.LoadIndex(indexFile)
+}
+
+// DBChecksum hashes everything the index is built from: the number and the
+// keywords of every comic. Each comic is hashed on its own and the hashes are
+// combined with XOR, so that the checksum can be updated one comic at a time.
+func DBChecksum(comics map[int]*ComicKeywords) string {
+	sum := make([]byte, sha256.Size)
+	for _, comic := range comics {
+		xorDocument(sum, comic.Num, comic.Keywords)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// IndexChecksum computes DBChecksum of the comics an index was built from.
+func IndexChecksum(index words.Index) string {
+	sum := make([]byte, sha256.Size)
+	for num, keywords := range index.Documents() {
+		xorDocument(sum, num, keywords)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// xorDocument adds a comic to, or removes it from, the checksum sum.
+// Comics without keywords do not show up in the index and are skipped.
+func xorDocument(sum []byte, num int, keywords []string) {
+	if len(keywords) == 0 {
+		return
+	}
+	sorted := append([]string(nil), keywords...)
+	sort.Strings(sorted)
+
+	hash := sha256.Sum256([]byte(strconv.Itoa(num) + ":" + strings.Join(sorted, " ")))
+	for i := range sum {
+		sum[i] ^= hash[i]
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+func TestVerifyDetectsUncommittedChanges(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+
+	if err := store.Save([]ComicKeywords{{Num: 1, Keywords: []string{"barrel"}}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	if err := BuildIndex(store, indexFile); err != nil {
+		t.Fatalf("Failed to build index: %v", err)
+	}
+	if consistent, err := Verify(store, indexFile); err != nil || !consistent {
+		t.Fatalf("Expected committed state to verify, got %v, %v", consistent, err)
+	}
+
+	// A crash after saving comics but before committing the index.
+	if err := store.Save([]ComicKeywords{{Num: 2, Keywords: []string{"petit"}}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	if consistent, _ := Verify(store, indexFile); consistent {
+		t.Errorf("Expected database ahead of the index to fail verification")
+	}
+
+	if err := Upgrade(store, indexFile); err != nil {
+		t.Fatalf("Failed to upgrade: %v", err)
+	}
+	if consistent, err := Verify(store, indexFile); err != nil || !consistent {
+		t.Errorf("Expected rebuilt index to verify, got %v, %v", consistent, err)
+	}
+
+	// A crash after replacing the index but before switching the manifest.
+	if err := os.WriteFile(indexFile, []byte(`{"barrel": [1]}`), 0666); err != nil {
+		t.Fatalf("Failed to overwrite index: %v", err)
+	}
+	if consistent, _ := Verify(store, indexFile); consistent {
+		t.Errorf("Expected index of another generation to fail verification")
+	}
+
+	manifest, err := ReadManifest(ManifestPath(indexFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Generation != 2 {
+		t.Errorf("Expected generation 2, got %d", manifest.Generation)
+	}
+}
+
+func TestIncrementalCommits(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+
+	batches := [][]ComicKeywords{
+		{{Num: 1, Keywords: []string{"barrel"}}, {Num: 2, Title: "Petit", Keywords: []string{"petit", "trees"}}},
+		{{Num: 3, Keywords: []string{"island"}}},
+		{{Num: 2, Title: "Sand", Keywords: []string{"sand"}}},
+		// A batch may hold several records of a comic; the last one is kept.
+		{{Num: 3, Keywords: []string{"reef"}}, {Num: 3, Keywords: []string{"island"}}},
+	}
+	for _, batch := range batches {
+		if err := commitComics(store, indexFile, batch); err != nil {
+			t.Fatalf("Failed to commit comics: %v", err)
+		}
+	}
+
+	segments, err := filepath.Glob(indexFile + ".*.ndjson")
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("Expected index segments after incremental commits, got %v, %v", segments, err)
+	}
+	if consistent, err := Verify(store, indexFile); err != nil || !consistent {
+		t.Fatalf("Expected incremental commits to verify, got %v, %v", consistent, err)
+	}
+
+	index, err := words.LoadIndex(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if _, ok := index.Terms["petit"]; ok {
+		t.Errorf("Expected postings of the replaced comic to be gone, got %v", index)
+	}
+	if ids := index.Terms["sand"]; len(ids) != 1 || ids[0].ID != 2 {
+		t.Errorf("Expected sand -> [2], got %v", ids)
+	}
+	title := index.Field("title")
+	if ids := title.Postings("sand"); len(ids) != 1 || ids[0].ID != 2 || title.Postings("petit") != nil {
+		t.Errorf("Expected the title postings of the replaced comic to be replaced, got %v", index.Fields["title"])
+	}
+
+	// Index records of a commit whose manifest switch never happened.
+	writer := indexWriter(indexFile)
+	writer.Add(4, []string{"ghost"}, []string{"ghosts"}, nil)
+	if err := writer.Commit(100); err != nil {
+		t.Fatalf("Failed to commit index records: %v", err)
+	}
+	if err := Recover(indexFile); err != nil {
+		t.Fatalf("Failed to recover: %v", err)
+	}
+	if index, _ := words.LoadIndex(indexFile); index.Terms["ghost"] != nil {
+		t.Errorf("Expected uncommitted postings to be rolled back")
+	}
+	if consistent, err := Verify(store, indexFile); err != nil || !consistent {
+		t.Errorf("Expected recovered state to verify, got %v, %v", consistent, err)
+	}
+}
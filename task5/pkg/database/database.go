@@ -4,18 +4,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
 )
 
+// SchemaVersion is the version of the ComicKeywords record layout written by this package.
+const SchemaVersion = 1
+
 type ComicKeywords struct {
-	Num      int      `json:"num"`
-	Img      string   `json:"img"`
-	Keywords []string `json:"keywords"`
+	SchemaVersion int             `json:"schema_version"`
+	Num           int             `json:"num"`
+	Title         string          `json:"title,omitempty"`
+	SafeTitle     string          `json:"safe_title,omitempty"`
+	Date          string          `json:"date,omitempty"`
+	Link          string          `json:"link,omitempty"`
+	News          string          `json:"news,omitempty"`
+	Img           string          `json:"img"`
+	ExtraParts    json.RawMessage `json:"extra_parts,omitempty"`
+	Keywords      []string        `json:"keywords"`
+	Raw           json.RawMessage `json:"raw,omitempty"`
+
+	// The image fields describe the local copy of Img, if it was mirrored.
+	ImageHash   string `json:"image_hash,omitempty"`
+	ImageSize   int64  `json:"image_size,omitempty"`
+	ImageWidth  int    `json:"image_width,omitempty"`
+	ImageHeight int    `json:"image_height,omitempty"`
+	// ImageDHash is the perceptual hash of the image in hex, see images.DHash.
+	ImageDHash string `json:"image_dhash,omitempty"`
+}
+
+// NewComicKeywords builds the stored record of a fetched comic.
+func NewComicKeywords(comic models.Comic) ComicKeywords {
+	return ComicKeywords{
+		SchemaVersion: SchemaVersion,
+		Num:           comic.Num,
+		Title:         comic.Title,
+		SafeTitle:     comic.SafeTitle,
+		Date:          comic.Date(),
+		Link:          comic.Link,
+		News:          comic.News,
+		Img:           comic.Img,
+		ExtraParts:    comic.ExtraParts,
+		Keywords:      words.NormalizeInput(comic.Transcript + " " + comic.Alt),
+		Raw:           comic.Raw,
+	}
 }
 
 type ComicFetcher interface {
@@ -29,163 +65,128 @@ var (
 
 const BufferSize = 10
 
-func SaveComicData(comic models.Comic, dbFile string) error {
+func SaveComicData(comic models.Comic, store Store, indexFile string) error {
+	record := NewComicKeywords(comic)
+	if Images != nil {
+		if err := mirrorImage(Images, &record); err != nil {
+			log.Printf("Failed to mirror image of comic %d: %v", comic.Num, err)
+		}
+	}
+
 	bufferMutex.Lock()
 	defer bufferMutex.Unlock()
 
-	ComicBuffer = append(ComicBuffer, ComicKeywords{
-		Num:      comic.Num,
-		Img:      comic.Img,
-		Keywords: words.NormalizeInput(comic.Transcript + " " + comic.Alt),
-	})
+	ComicBuffer = append(ComicBuffer, record)
 
 	if len(ComicBuffer) >= BufferSize {
-		if err := FlushComicData(dbFile); err != nil {
-			return err
-		}
-		indexFile := filepath.Join(filepath.Dir(dbFile), "index.json")
-		if err := BuildIndex(dbFile, indexFile); err != nil {
-			return err
-		}
+		return FlushComicData(store, indexFile)
 	}
 	return nil
 }
 
-func MaybeFlushComicData(dbFile string) error {
+func MaybeFlushComicData(store Store, indexFile string) error {
 	bufferMutex.Lock()
 	defer bufferMutex.Unlock()
 	if len(ComicBuffer) > 0 {
-		return FlushComicData(dbFile)
+		return FlushComicData(store, indexFile)
 	}
 	return nil
 }
 
-func FlushComicData(dbFile string) error {
-	tempFile := dbFile + ".tmp"
-	existingData, err := os.ReadFile(dbFile)
-	if err == nil && len(existingData) > 0 {
-		var existingComics []ComicKeywords
-		if err := json.Unmarshal(existingData, &existingComics); err != nil {
-			return fmt.Errorf("error decoding JSON from %s: %v", dbFile, err)
-		}
-		ComicBuffer = append(existingComics, ComicBuffer...)
+// FlushComicData saves the buffered comics and commits their index postings.
+func FlushComicData(store Store, indexFile string) error {
+	if err := commitComics(store, indexFile, ComicBuffer); err != nil {
+		return err
 	}
-
-	newData, err := json.MarshalIndent(ComicBuffer, "", " ")
-	if err != nil {
-		return fmt.Errorf("error encoding JSON to %s: %v", tempFile, err)
-	}
-
-	if err := os.WriteFile(tempFile, newData, 0666); err != nil {
-		return fmt.Errorf("error writing to %s: %v", tempFile, err)
-	}
-
-	if err := os.Rename(tempFile, dbFile); err != nil {
-		return fmt.Errorf("error renaming %s to %s: %v", tempFile, dbFile, err)
+	if Revisions != nil {
+		if _, err := Revisions.Record(time.Now(), ComicBuffer...); err != nil {
+			return fmt.Errorf("failed to record revisions: %v", err)
+		}
 	}
-
 	ComicBuffer = nil
 	return nil
 }
 
-func GetLastComicNum(dbFile string) (int, map[int]bool) {
-	file, err := os.Open(dbFile)
+func GetLastComicNum(store Store) (int, map[int]bool) {
+	maxNum, existingNums, err := store.LastNum()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
-		}
-		log.Fatalf("Failed to open database.json: %v", err)
-	}
-	defer file.Close()
-
-	var comics []ComicKeywords
-	if err := json.NewDecoder(file).Decode(&comics); err != nil {
-		log.Fatalf("Failed to decode database.json: %v", err)
-	}
-
-	maxNum := 0
-	existingNums := make(map[int]bool)
-	for _, comic := range comics {
-		if comic.Num > maxNum {
-			maxNum = comic.Num
-		}
-		existingNums[comic.Num] = true
+		log.Fatalf("Failed to read comic numbers: %v", err)
 	}
 	return maxNum, existingNums
 }
 
-func BuildIndex(dbFile string, indexFile string) error {
-	file, err := os.Open(dbFile)
+func BuildIndex(store Store, indexFile string) error {
+	lock, err := LockExclusive(commitLockPath(indexFile))
 	if err != nil {
-		return fmt.Errorf("failed to open database file: %v", err)
+		return err
 	}
-	defer file.Close()
+	defer lock.Unlock()
 
-	var comics []ComicKeywords
-	if err := json.NewDecoder(file).Decode(&comics); err != nil {
-		return fmt.Errorf("failed to decode database: %v", err)
-	}
-
-	index := make(map[string][]int)
-	for _, comic := range comics {
-		for _, keyword := range comic.Keywords {
-			index[keyword] = append(index[keyword], comic.Num)
-		}
-	}
+	return buildIndex(store, indexFile)
+}
 
-	indexData, err := json.MarshalIndent(index, "", "  ")
+func buildIndex(store Store, indexFile string) error {
+	comics, err := store.All()
 	if err != nil {
-		return fmt.Errorf("failed to encode index: %v", err)
+		return fmt.Errorf("failed to load database: %v", err)
 	}
 
-	if err := os.WriteFile(indexFile, indexData, 0666); err != nil {
-		return fmt.Errorf("failed to write index file: %v", err)
-	}
-
-	return nil
+	return commitIndex(comics, indexFile)
 }
 
-func GetComicByID(dbFile string, id int) (*ComicKeywords, error) {
-	file, err := os.Open(dbFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database file: %v", err)
+// MakeIndex builds the keyword index of the given comics in memory.
+func MakeIndex(comics map[int]*ComicKeywords) words.Index {
+	nums := make([]int, 0, len(comics))
+	for num := range comics {
+		nums = append(nums, num)
 	}
-	defer file.Close()
+	sort.Ints(nums)
 
-	var comics []ComicKeywords
-	if err := json.NewDecoder(file).Decode(&comics); err != nil {
-		return nil, fmt.Errorf("failed to decode database: %v", err)
+	index := words.NewIndex()
+	for _, num := range nums {
+		index.Add(num, comics[num].Keywords)
+		index.AddForms(num, comicForms(comics[num]))
+		index.AddFields(num, comicFields(comics[num]))
 	}
-
-	for _, comic := range comics {
-		if comic.Num == id {
-			return &comic, nil
-		}
-	}
-	return nil, fmt.Errorf("comic not found")
+	return index
 }
 
-func LoadAllComics(dbFile string) (map[int]*ComicKeywords, error) {
-	file, err := os.Open(dbFile)
+// comicForms returns the surface forms of the keywords of a comic, which
+// are taken from its stored upstream JSON. Comics stored without it have none.
+func comicForms(comic *ComicKeywords) []string {
+	upstream, err := decodeRaw(*comic)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database file: %v", err)
+		return nil
 	}
-	defer file.Close()
+	return words.SurfaceForms(upstream.Transcript + " " + upstream.Alt)
+}
 
-	var comics []ComicKeywords
-	if err := json.NewDecoder(file).Decode(&comics); err != nil {
-		return nil, fmt.Errorf("failed to decode database: %v", err)
-	}
+// Fields are the fields of the comics that are indexed on their own, besides
+// the keywords of the alt text and the transcript together.
+var Fields = []string{"title", "alt", "transcript"}
 
-	comicsMap := make(map[int]*ComicKeywords)
-	for i := range comics {
-		comicsMap[comics[i].Num] = &comics[i]
+// comicFields returns the keywords of every field of a comic. The alt text
+// and the transcript are taken from its stored upstream JSON, so comics
+// stored without it have only a title.
+func comicFields(comic *ComicKeywords) map[string][]string {
+	fields := map[string][]string{"title": words.NormalizeInput(comic.Title)}
+	if upstream, err := decodeRaw(*comic); err == nil {
+		fields["alt"] = words.NormalizeInput(upstream.Alt)
+		fields["transcript"] = words.NormalizeInput(upstream.Transcript)
 	}
-	return comicsMap, nil
+	return fields
+}
+
+func GetComicByID(store Store, id int) (*ComicKeywords, error) {
+	return store.Get(id)
+}
+
+func LoadAllComics(store Store) (map[int]*ComicKeywords, error) {
+	return store.All()
 }
 
-func UpdateComics(dbFile string, fetcher ComicFetcher) (int, int, error) {
-	lastComicNum, existingComics := GetLastComicNum(dbFile)
+func UpdateComics(store Store, indexFile string, fetcher ComicFetcher) (int, int, error) {
+	lastComicNum, existingComics := GetLastComicNum(store)
 	newComicsCount := 0
 	for i := lastComicNum + 1; ; i++ {
 		comic, err := fetcher.FetchComic(i)
@@ -195,7 +196,7 @@ func UpdateComics(dbFile string, fetcher ComicFetcher) (int, int, error) {
 		if existingComics[comic.Num] {
 			continue
 		}
-		err = SaveComicData(*comic, dbFile)
+		err = SaveComicData(*comic, store, indexFile)
 		if err != nil {
 			log.Printf("Failed to save comic %d: %v", comic.Num, err)
 			continue
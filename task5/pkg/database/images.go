@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
+)
+
+// Images, if set, receives a copy of the image of every comic saved by
+// SaveComicData, and RefreshComics fills in the images missing from it.
+var Images *images.Mirror
+
+// MirrorImages downloads the images of stored comics that are missing from
+// the mirror, including evicted ones, and records them. Images mirrored
+// before perceptual hashes were recorded get their hash from the local copy.
+// It returns how many comics were updated. Comics whose image fails to
+// download are logged and skipped.
+func MirrorImages(store Store, indexFile string, mirror *images.Mirror) (int, error) {
+	comics, err := store.All()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load comics: %v", err)
+	}
+	nums := make([]int, 0, len(comics))
+	for num, comic := range comics {
+		if comic.Img != "" && (comic.ImageDHash == "" || !mirror.Has(comic.ImageHash)) {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+
+	var mirrored []ComicKeywords
+	for _, num := range nums {
+		comic := comics[num]
+		if mirror.Has(comic.ImageHash) {
+			hash, err := mirror.DHash(comic.ImageHash)
+			if err == nil {
+				comic.ImageDHash = images.FormatHash(hash)
+				mirrored = append(mirrored, *comic)
+				continue
+			}
+			log.Printf("Failed to hash image of comic %d: %v", num, err)
+		}
+		if err := mirrorImage(mirror, comic); err != nil {
+			log.Printf("Failed to mirror image of comic %d: %v", num, err)
+			continue
+		}
+		mirrored = append(mirrored, *comic)
+	}
+	if err := saveImageDetails(store, indexFile, mirrored); err != nil {
+		return 0, err
+	}
+	return len(mirrored), nil
+}
+
+func saveImageDetails(store Store, indexFile string, comics []ComicKeywords) error {
+	if len(comics) == 0 {
+		return nil
+	}
+	// The keywords are unchanged, so the index stays valid; the lock only
+	// keeps the save out of the way of commits and snapshots.
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err := store.Save(comics); err != nil {
+		return fmt.Errorf("failed to save image details: %v", err)
+	}
+	return nil
+}
+
+func mirrorImage(mirror *images.Mirror, comic *ComicKeywords) error {
+	if comic.Img == "" {
+		return nil
+	}
+	info, err := mirror.Download(comic.Img)
+	if err != nil {
+		return err
+	}
+	comic.ImageHash = info.Hash
+	comic.ImageSize = info.Size
+	comic.ImageWidth = info.Width
+	comic.ImageHeight = info.Height
+	comic.ImageDHash = images.FormatHash(info.DHash)
+	return nil
+}
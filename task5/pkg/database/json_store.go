@@ -0,0 +1,414 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+const (
+	// maxSegmentSize is the size after which a new log segment is started.
+	maxSegmentSize = 1 << 20
+	// maxSegments is the number of log segments that triggers a compaction.
+	maxSegments = 8
+)
+
+// JSONStore keeps comics in a JSON array file (the base) followed by
+// append-only NDJSON log segments next to it. Saving only appends to the
+// newest segment; compaction folds all segments back into the base file.
+// A later record for the same comic number replaces an earlier one.
+// Writers take an exclusive and readers a shared lock on a lock file, so
+// several processes can use the same files.
+type JSONStore struct {
+	path string
+
+	mu     sync.Mutex
+	comics map[int]*ComicKeywords
+	state  string
+	// torn is set while the view skips a torn record at the end of the
+	// last segment that is yet to be cut off.
+	torn bool
+}
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path}
+}
+
+func (s *JSONStore) Save(comics []ComicKeywords) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockExclusive(s.lockPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := s.refresh(true); err != nil {
+		return err
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	active := files.ActiveSegment(s.path, segments, maxSegmentSize)
+	if len(segments) == 0 || active != segments[len(segments)-1] {
+		segments = append(segments, active)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, comic := range comics {
+		if err := encoder.Encode(comic); err != nil {
+			return fmt.Errorf("error encoding comic %d: %v", comic.Num, err)
+		}
+	}
+
+	if err := files.AppendSynced(active, buf.Bytes()); err != nil {
+		return err
+	}
+
+	for i := range comics {
+		comic := comics[i]
+		s.comics[comic.Num] = &comic
+	}
+	if s.state, err = s.fileState(); err != nil {
+		return err
+	}
+
+	if len(segments) >= maxSegments {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *JSONStore) Get(num int) (*ComicKeywords, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockShared(s.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	if err := s.refresh(false); err != nil {
+		return nil, err
+	}
+	comic, ok := s.comics[num]
+	if !ok {
+		return nil, ErrComicNotFound
+	}
+	copied := *comic
+	return &copied, nil
+}
+
+func (s *JSONStore) All() (map[int]*ComicKeywords, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockShared(s.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	if err := s.refresh(false); err != nil {
+		return nil, err
+	}
+	comicsMap := make(map[int]*ComicKeywords, len(s.comics))
+	for num, comic := range s.comics {
+		copied := *comic
+		comicsMap[num] = &copied
+	}
+	return comicsMap, nil
+}
+
+func (s *JSONStore) LastNum() (int, map[int]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockShared(s.lockPath())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer lock.Unlock()
+
+	if err := s.refresh(false); err != nil {
+		return 0, nil, err
+	}
+	if len(s.comics) == 0 {
+		return 0, nil, nil
+	}
+
+	maxNum := 0
+	existingNums := make(map[int]bool, len(s.comics))
+	for num := range s.comics {
+		if num > maxNum {
+			maxNum = num
+		}
+		existingNums[num] = true
+	}
+	return maxNum, existingNums, nil
+}
+
+// Compact rewrites the base file from the current view and removes all log segments.
+func (s *JSONStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockExclusive(s.lockPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := s.refresh(true); err != nil {
+		return err
+	}
+	return s.compact()
+}
+
+// RecordCount returns the number of records in the base file and the
+// segments, including the ones superseded by a later record.
+func (s *JSONStore) RecordCount() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockShared(s.lockPath())
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	base, err := s.loadBase()
+	if err != nil {
+		return 0, err
+	}
+	count := len(base)
+
+	segments, err := s.segments()
+	if err != nil {
+		return 0, err
+	}
+	for i, segment := range segments {
+		_, err := replaySegment(segment, i == len(segments)-1, false, func(ComicKeywords) {
+			count++
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// SnapshotFiles returns the base file and the segments as they are on disk, keyed by file name.
+func (s *JSONStore) SnapshotFiles() (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := LockShared(s.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(segments)+1)
+	for _, path := range append([]string{s.path}, segments...) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		files[filepath.Base(path)] = data
+	}
+	return files, nil
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+func (s *JSONStore) compact() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	comics := make([]ComicKeywords, 0, len(s.comics))
+	for _, comic := range s.comics {
+		comics = append(comics, *comic)
+	}
+	sort.Slice(comics, func(i, j int) bool {
+		return comics[i].Num < comics[j].Num
+	})
+
+	newData, err := json.MarshalIndent(comics, "", " ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON to %s: %v", s.path, err)
+	}
+	if err := files.WriteAtomic(s.path, newData); err != nil {
+		return err
+	}
+
+	// Segments left behind by a crash here are replayed over the new base,
+	// which is harmless because records replace each other by number.
+	for _, segment := range segments {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing segment %s: %v", segment, err)
+		}
+	}
+
+	s.state, err = s.fileState()
+	return err
+}
+
+// refresh rebuilds the in-memory view when the files on disk have changed
+// since it was built. A torn record at the end of the last segment is only
+// cut off if truncate is set, which needs the exclusive lock; readers
+// holding the shared lock skip it.
+func (s *JSONStore) refresh(truncate bool) error {
+	state, err := s.fileState()
+	if err != nil {
+		return err
+	}
+	if s.comics != nil && state == s.state && !(truncate && s.torn) {
+		return nil
+	}
+
+	comics := make(map[int]*ComicKeywords)
+	base, err := s.loadBase()
+	if err != nil {
+		return err
+	}
+	for i := range base {
+		comics[base[i].Num] = &base[i]
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	torn := false
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		torn, err = replaySegment(segment, last, truncate, func(comic ComicKeywords) {
+			comics[comic.Num] = &comic
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.comics, s.torn = comics, torn
+	// Recovery may have truncated the last segment, so take the state afterwards.
+	s.state, err = s.fileState()
+	return err
+}
+
+func (s *JSONStore) loadBase() ([]ComicKeywords, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var comics []ComicKeywords
+	if err := json.Unmarshal(data, &comics); err != nil {
+		return nil, fmt.Errorf("error decoding JSON from %s: %v", s.path, err)
+	}
+	return comics, nil
+}
+
+// replaySegment calls apply with all records of a segment. A torn record at
+// the end of the last segment, left by a crash during append, is skipped and
+// cut off if truncate is set; it returns whether one was skipped.
+func replaySegment(segment string, last, truncate bool, apply func(comic ComicKeywords)) (bool, error) {
+	file, err := os.Open(segment)
+	if err != nil {
+		return false, fmt.Errorf("error opening segment %s: %v", segment, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("error reading segment %s: %v", segment, err)
+		}
+		if len(line) == 0 {
+			return false, nil
+		}
+
+		var comic ComicKeywords
+		complete := line[len(line)-1] == '\n'
+		if !complete || json.Unmarshal(line, &comic) != nil {
+			if !last {
+				return false, fmt.Errorf("corrupted record in segment %s at offset %d", segment, offset)
+			}
+			if !truncate {
+				return true, nil
+			}
+			if err := os.Truncate(segment, offset); err != nil {
+				return false, fmt.Errorf("error truncating torn record in %s: %v", segment, err)
+			}
+			return false, nil
+		}
+
+		apply(comic)
+		offset += int64(len(line))
+	}
+}
+
+func (s *JSONStore) segments() ([]string, error) {
+	return files.Segments(s.path)
+}
+
+func (s *JSONStore) lockPath() string {
+	return s.path + ".lock"
+}
+
+// fileState describes the base file and segments so that changes made by other processes can be noticed.
+func (s *JSONStore) fileState() (string, error) {
+	var state strings.Builder
+	if info, err := os.Stat(s.path); err == nil {
+		fmt.Fprintf(&state, "%d:%d;", info.Size(), info.ModTime().UnixNano())
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return "", err
+	}
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&state, "%s:%d;", filepath.Base(segment), info.Size())
+	}
+	return state.String(), nil
+}
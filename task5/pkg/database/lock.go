@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockTimeout is how long to wait for a lock held by another process.
+var LockTimeout = 30 * time.Second
+
+const lockRetryInterval = 50 * time.Millisecond
+
+var ErrLocked = errors.New("locked by another process")
+
+// FileLock is an advisory lock on a lock file shared between processes.
+type FileLock struct {
+	file *os.File
+}
+
+// LockShared takes a shared lock on path for readers.
+func LockShared(path string) (*FileLock, error) {
+	return lockFile(path, false)
+}
+
+// LockExclusive takes an exclusive lock on path for writers.
+func LockExclusive(path string) (*FileLock, error) {
+	return lockFile(path, true)
+}
+
+func lockFile(path string, exclusive bool) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		locked, err := tryLock(file, exclusive)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if locked {
+			return &FileLock{file: file}, nil
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w after waiting %s", path, ErrLocked, LockTimeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *FileLock) Unlock() error {
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// commitLockPath returns the lock file guarding the index and the manifest.
+func commitLockPath(indexFile string) string {
+	return indexFile + ".lock"
+}
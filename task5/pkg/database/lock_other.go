@@ -0,0 +1,24 @@
+//go:build !unix
+
+package database
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+var lockWarning sync.Once
+
+// Advisory locks are only implemented with flock. Elsewhere locking always
+// succeeds, so only one process at a time may use the database files.
+func tryLock(file *os.File, exclusive bool) (bool, error) {
+	lockWarning.Do(func() {
+		log.Printf("File locking is not supported on this system, do not run several processes on the same database")
+	})
+	return true, nil
+}
+
+func unlock(file *os.File) error {
+	return nil
+}
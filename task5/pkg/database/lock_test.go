@@ -0,0 +1,55 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLocks(t *testing.T) {
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 100 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "database.lock")
+
+	first, err := LockShared(path)
+	if err != nil {
+		t.Fatalf("Failed to take shared lock: %v", err)
+	}
+	second, err := LockShared(path)
+	if err != nil {
+		t.Fatalf("Expected shared locks to coexist: %v", err)
+	}
+
+	if _, err := LockExclusive(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while readers hold the lock, got %v", err)
+	}
+
+	first.Unlock()
+	second.Unlock()
+
+	writer, err := LockExclusive(path)
+	if err != nil {
+		t.Fatalf("Failed to take exclusive lock: %v", err)
+	}
+	if _, err := LockShared(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while a writer holds the lock, got %v", err)
+	}
+
+	LockTimeout = time.Second
+	released := make(chan error)
+	go func() {
+		lock, err := LockShared(path)
+		if err == nil {
+			lock.Unlock()
+		}
+		released <- err
+	}()
+	time.Sleep(2 * lockRetryInterval)
+	writer.Unlock()
+	if err := <-released; err != nil {
+		t.Errorf("Expected reader to get the lock once the writer released it, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// Compacter is implemented by stores that can drop superseded records.
+type Compacter interface {
+	Compact() error
+}
+
+// RecordCounter is implemented by stores that can keep more than one record per comic.
+type RecordCounter interface {
+	RecordCount() (int, error)
+}
+
+// Report describes the state of the database and of its index.
+type Report struct {
+	Comics     int
+	Records    int
+	Duplicates int
+	LastNum    int
+	Gaps       []int
+
+	Terms    int
+	Postings int
+
+	// MissingFromIndex are comics with keywords that have no postings.
+	MissingFromIndex []int
+	// Orphaned are comics the index has postings for that are not in the database.
+	Orphaned []int
+	// Stale are comics whose postings differ from their keywords.
+	Stale []int
+
+	Manifest   Manifest
+	Consistent bool
+}
+
+// Problems returns the number of issues found that repair can fix.
+func (r Report) Problems() int {
+	problems := r.Duplicates + len(r.MissingFromIndex) + len(r.Orphaned) + len(r.Stale)
+	if !r.Consistent && problems == 0 {
+		problems++
+	}
+	return problems
+}
+
+// Inspect compares the database with its index without changing either.
+func Inspect(store Store, indexFile string) (Report, error) {
+	lock, err := LockShared(commitLockPath(indexFile))
+	if err != nil {
+		return Report{}, err
+	}
+	defer lock.Unlock()
+
+	var report Report
+	comics, err := LoadAllComics(store)
+	if err != nil {
+		return report, fmt.Errorf("failed to load comics: %v", err)
+	}
+	report.Comics = len(comics)
+	report.Records = len(comics)
+	if counter, ok := store.(RecordCounter); ok {
+		if report.Records, err = counter.RecordCount(); err != nil {
+			return report, err
+		}
+	}
+	report.Duplicates = report.Records - report.Comics
+
+	for num := range comics {
+		if num > report.LastNum {
+			report.LastNum = num
+		}
+	}
+	for num := 1; num < report.LastNum; num++ {
+		if _, ok := comics[num]; !ok {
+			report.Gaps = append(report.Gaps, num)
+		}
+	}
+
+	index, err := words.LoadIndex(indexFile)
+	if err != nil {
+		return report, err
+	}
+	report.Terms = len(index.Terms)
+	for _, postings := range index.Terms {
+		report.Postings += len(postings)
+	}
+
+	documents := index.Documents()
+	for num, comic := range comics {
+		postings, ok := documents[num]
+		switch {
+		case len(comic.Keywords) == 0:
+			continue
+		case !ok:
+			report.MissingFromIndex = append(report.MissingFromIndex, num)
+		case !sameKeywords(comic.Keywords, postings):
+			report.Stale = append(report.Stale, num)
+		}
+	}
+	for num := range documents {
+		if _, ok := comics[num]; !ok {
+			report.Orphaned = append(report.Orphaned, num)
+		}
+	}
+	sort.Ints(report.MissingFromIndex)
+	sort.Ints(report.Orphaned)
+	sort.Ints(report.Stale)
+
+	if report.Manifest, err = ReadManifest(ManifestPath(indexFile)); err != nil {
+		return report, err
+	}
+	report.Consistent = report.Manifest.Generation > 0 &&
+		DBChecksum(comics) == report.Manifest.DBChecksum &&
+		IndexChecksum(index) == report.Manifest.DBChecksum
+	return report, nil
+}
+
+// Repair drops duplicate records and rebuilds the index from the database.
+func Repair(store Store, indexFile string) error {
+	if compacter, ok := store.(Compacter); ok {
+		if err := compacter.Compact(); err != nil {
+			return fmt.Errorf("failed to compact database: %v", err)
+		}
+	}
+	return BuildIndex(store, indexFile)
+}
+
+// Compact folds the log segments of the database and of the index into their base files.
+func Compact(store Store, indexFile string) error {
+	if compacter, ok := store.(Compacter); ok {
+		if err := compacter.Compact(); err != nil {
+			return fmt.Errorf("failed to compact database: %v", err)
+		}
+	}
+
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := recoverIndex(indexFile); err != nil {
+		return err
+	}
+	return indexWriter(indexFile).Merge()
+}
+
+func sameKeywords(keywords, sorted []string) bool {
+	if len(keywords) != len(sorted) {
+		return false
+	}
+	copied := append([]string(nil), keywords...)
+	sort.Strings(copied)
+	for i := range copied {
+		if copied[i] != sorted[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInspectAndRepair(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+
+	comics := []ComicKeywords{
+		{Num: 1, Keywords: []string{"barrel"}},
+		{Num: 2, Keywords: []string{"petit"}},
+		{Num: 5, Keywords: []string{"island"}},
+	}
+	if err := store.Save(comics); err != nil {
+		t.Fatalf("Failed to save comics: %v", err)
+	}
+	// The same comic appended twice, as a crawl and an update both can.
+	if err := store.Save(comics[:1]); err != nil {
+		t.Fatalf("Failed to save comics: %v", err)
+	}
+	index := `{"barrel": [1], "petit": [2, 2], "ghost": [9]}`
+	if err := os.WriteFile(indexFile, []byte(index), 0666); err != nil {
+		t.Fatalf("Failed to write index: %v", err)
+	}
+
+	report, err := Inspect(store, indexFile)
+	if err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	if report.Comics != 3 || report.Records != 4 || report.Duplicates != 1 || report.LastNum != 5 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	if !reflect.DeepEqual(report.Gaps, []int{3, 4}) {
+		t.Errorf("Expected gaps [3 4], got %v", report.Gaps)
+	}
+	if !reflect.DeepEqual(report.MissingFromIndex, []int{5}) ||
+		!reflect.DeepEqual(report.Orphaned, []int{9}) ||
+		!reflect.DeepEqual(report.Stale, []int{2}) {
+		t.Errorf("Unexpected drift: missing %v, orphaned %v, stale %v", report.MissingFromIndex, report.Orphaned, report.Stale)
+	}
+	if report.Consistent {
+		t.Errorf("Expected drift to be inconsistent")
+	}
+
+	if err := Repair(store, indexFile); err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	report, err = Inspect(store, indexFile)
+	if err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	if report.Problems() != 0 || !report.Consistent {
+		t.Errorf("Expected no problems after repair, got %+v", report)
+	}
+}
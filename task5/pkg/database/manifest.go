@@ -0,0 +1,157 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// Manifest records which versions of the record schema and of the analyzer
+// the stored comics and the index were written with, and the generation the
+// database and the index were last committed together in.
+type Manifest struct {
+	SchemaVersion        int    `json:"schema_version"`
+	AnalyzerVersion      int    `json:"analyzer_version"`
+	IndexAnalyzerVersion int    `json:"index_analyzer_version"`
+	IndexFormat          int    `json:"index_format"`
+	Generation           uint64 `json:"generation"`
+	DBChecksum           string `json:"db_checksum"`
+}
+
+// ManifestPath returns the manifest file kept next to the index file.
+func ManifestPath(indexFile string) string {
+	return filepath.Join(filepath.Dir(indexFile), "manifest.json")
+}
+
+// ReadManifest reads the manifest. A missing manifest describes files written
+// before versioning was introduced and reads as all zero versions.
+func ReadManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return manifest, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to decode manifest %s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+func WriteManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	return files.WriteAtomic(path, data)
+}
+
+// Upgrade brings the stored comics and the index up to the versions of the
+// running code: it migrates records, reanalyzes keywords produced by another
+// analyzer and rebuilds an index built by another analyzer or in an older format.
+func Upgrade(store Store, indexFile string) error {
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	manifestFile := ManifestPath(indexFile)
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		return err
+	}
+
+	// Records whose metadata has to be fetched again are left to -migrate;
+	// the schema version is only raised once none of them is left.
+	if manifest.SchemaVersion < SchemaVersion {
+		migrated, remaining, err := MigrateComics(store, nil)
+		if err != nil {
+			return err
+		}
+		if migrated > 0 {
+			log.Printf("Migrated %d comics from schema version %d to %d", migrated, manifest.SchemaVersion, SchemaVersion)
+		}
+		if remaining > 0 {
+			log.Printf("%d comics are stored with a schema version below %d, run with -migrate to upgrade them", remaining, SchemaVersion)
+		} else {
+			manifest.SchemaVersion = SchemaVersion
+		}
+	}
+
+	if manifest.AnalyzerVersion != words.AnalyzerVersion {
+		reanalyzed, err := ReanalyzeComics(store)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reanalyzed %d comics with analyzer version %d", reanalyzed, words.AnalyzerVersion)
+		manifest.AnalyzerVersion = words.AnalyzerVersion
+	}
+
+	if err := WriteManifest(manifestFile, manifest); err != nil {
+		return err
+	}
+
+	if err := recoverIndex(indexFile); err != nil {
+		return err
+	}
+
+	if manifest.IndexAnalyzerVersion != words.AnalyzerVersion {
+		log.Printf("Rebuilding index %s with analyzer version %d", indexFile, words.AnalyzerVersion)
+		return buildIndex(store, indexFile)
+	}
+	if manifest.IndexFormat != words.IndexFormat {
+		log.Printf("Rebuilding index %s in format %d", indexFile, words.IndexFormat)
+		return buildIndex(store, indexFile)
+	}
+
+	consistent, err := verify(store, indexFile)
+	if err != nil {
+		return err
+	}
+	if !consistent {
+		log.Printf("Index %s does not match the database of generation %d, rebuilding it", indexFile, manifest.Generation)
+		return buildIndex(store, indexFile)
+	}
+	return nil
+}
+
+// ReanalyzeComics recomputes the keywords of every comic that kept its
+// upstream JSON. Comics stored without it keep their keywords.
+// It returns the number of updated comics.
+func ReanalyzeComics(store Store) (int, error) {
+	comics, err := store.All()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load database: %v", err)
+	}
+
+	var updated []ComicKeywords
+	for _, comic := range comics {
+		if len(comic.Raw) == 0 {
+			continue
+		}
+		var upstream models.Comic
+		if err := json.Unmarshal(comic.Raw, &upstream); err != nil {
+			log.Printf("Failed to decode stored JSON of comic %d: %v", comic.Num, err)
+			continue
+		}
+		comic.Keywords = words.NormalizeInput(upstream.Transcript + " " + upstream.Alt)
+		updated = append(updated, *comic)
+	}
+
+	if len(updated) == 0 {
+		return 0, nil
+	}
+	if err := store.Save(updated); err != nil {
+		return 0, fmt.Errorf("failed to save reanalyzed comics: %v", err)
+	}
+	return len(updated), nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"fmt"
+	"log"
+)
+
+// MigrateComics upgrades records stored with an older schema version.
+// Version 0 records only kept the image and keywords; if fetcher is not nil
+// their metadata is fetched again, otherwise they are left for a later run.
+// It returns the number of migrated records and of records still stored with
+// an older schema version.
+func MigrateComics(store Store, fetcher ComicFetcher) (migrated, remaining int, err error) {
+	comics, err := store.All()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load database: %v", err)
+	}
+
+	var upgraded []ComicKeywords
+	for _, comic := range comics {
+		if comic.SchemaVersion >= SchemaVersion {
+			continue
+		}
+		if fetcher == nil {
+			remaining++
+			continue
+		}
+		migratedComic, ok := migrateComic(*comic, fetcher)
+		if !ok {
+			remaining++
+			continue
+		}
+		upgraded = append(upgraded, migratedComic)
+	}
+
+	if len(upgraded) == 0 {
+		return 0, remaining, nil
+	}
+	if err := store.Save(upgraded); err != nil {
+		return 0, 0, fmt.Errorf("failed to save migrated comics: %v", err)
+	}
+	return len(upgraded), remaining, nil
+}
+
+func migrateComic(comic ComicKeywords, fetcher ComicFetcher) (ComicKeywords, bool) {
+	switch comic.SchemaVersion {
+	case 0:
+		if fetcher == nil {
+			return comic, false
+		}
+		fetched, err := fetcher.FetchComic(comic.Num)
+		if err != nil {
+			log.Printf("Failed to fetch metadata of comic %d: %v", comic.Num, err)
+			return comic, false
+		}
+		keywords := comic.Keywords
+		comic = NewComicKeywords(*fetched)
+		comic.Keywords = keywords
+	}
+	return comic, true
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+type fakeFetcher map[int]models.Comic
+
+func (f fakeFetcher) FetchComic(num int) (*models.Comic, error) {
+	comic, ok := f[num]
+	if !ok {
+		return nil, fmt.Errorf("comic %d not found", num)
+	}
+	return &comic, nil
+}
+
+func TestMigrateComics(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "database.json"))
+	legacy := []ComicKeywords{
+		{Num: 1, Img: "one.png", Keywords: []string{"barrel"}},
+		{Num: 2, Img: "two.png", Keywords: []string{"petit"}},
+	}
+	if err := store.Save(legacy); err != nil {
+		t.Fatalf("Failed to save comics: %v", err)
+	}
+
+	if migrated, remaining, err := MigrateComics(store, nil); err != nil || migrated != 0 || remaining != 2 {
+		t.Fatalf("Expected nothing migrated without fetcher, got %d migrated, %d remaining, %v", migrated, remaining, err)
+	}
+
+	fetcher := fakeFetcher{
+		1: {Num: 1, Title: "Barrel - Part 1", Year: "2006", Month: "1", Day: "1", Img: "one.png"},
+	}
+	migrated, remaining, err := MigrateComics(store, fetcher)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if migrated != 1 || remaining != 1 {
+		t.Errorf("Expected 1 migrated and 1 remaining comic, got %d and %d", migrated, remaining)
+	}
+
+	comic, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("Failed to get comic: %v", err)
+	}
+	if comic.SchemaVersion != SchemaVersion || comic.Title != "Barrel - Part 1" || comic.Date != "2006-01-01" {
+		t.Errorf("Unexpected migrated comic: %+v", comic)
+	}
+	if len(comic.Keywords) != 1 || comic.Keywords[0] != "barrel" {
+		t.Errorf("Expected keywords to be kept, got %v", comic.Keywords)
+	}
+
+	if comic, _ := store.Get(2); comic.SchemaVersion != 0 {
+		t.Errorf("Expected comic 2 to stay at version 0, got %d", comic.SchemaVersion)
+	}
+}
+
+func TestUpgradeReanalyzesAndRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+
+	comic := ComicKeywords{
+		SchemaVersion: SchemaVersion,
+		Num:           1,
+		Keywords:      []string{"stale"},
+		Raw:           []byte(`{"num":1,"transcript":"barrels","alt":"islands"}`),
+	}
+	if err := store.Save([]ComicKeywords{comic}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+
+	if err := Upgrade(store, indexFile); err != nil {
+		t.Fatalf("Failed to upgrade: %v", err)
+	}
+
+	upgraded, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("Failed to get comic: %v", err)
+	}
+	if len(upgraded.Keywords) != 2 || upgraded.Keywords[0] != "barrel" || upgraded.Keywords[1] != "island" {
+		t.Errorf("Expected reanalyzed keywords, got %v", upgraded.Keywords)
+	}
+
+	manifest, err := ReadManifest(ManifestPath(indexFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.SchemaVersion != SchemaVersion || manifest.AnalyzerVersion != words.AnalyzerVersion ||
+		manifest.IndexAnalyzerVersion != words.AnalyzerVersion || manifest.IndexFormat != words.IndexFormat || manifest.Generation != 1 {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+
+	index, err := words.LoadIndex(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if _, ok := index.Terms["stale"]; ok {
+		t.Errorf("Expected index to be rebuilt from reanalyzed keywords")
+	}
+	if ids := index.Forms["barrels"]; len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("Expected the surface forms to be indexed, got %v", index.Forms)
+	}
+}
+
+func TestUpgradeKeepsSchemaVersionOfUnmigratedComics(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+	if err := store.Save([]ComicKeywords{{Num: 1, Img: "one.png", Keywords: []string{"barrel"}}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+
+	if err := Upgrade(store, indexFile); err != nil {
+		t.Fatalf("Failed to upgrade: %v", err)
+	}
+	manifest, err := ReadManifest(ManifestPath(indexFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.SchemaVersion != 0 {
+		t.Errorf("Expected schema version 0 while a comic is not migrated, got %d", manifest.SchemaVersion)
+	}
+
+	if _, _, err := MigrateComics(store, fakeFetcher{1: {Num: 1, Img: "one.png"}}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if err := Upgrade(store, indexFile); err != nil {
+		t.Fatalf("Failed to upgrade: %v", err)
+	}
+	if manifest, _ := ReadManifest(ManifestPath(indexFile)); manifest.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected schema version %d once all comics are migrated, got %d", SchemaVersion, manifest.SchemaVersion)
+	}
+}
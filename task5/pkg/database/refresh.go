@@ -0,0 +1,168 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+// RefreshState is where the refresh rotation continues, kept next to the manifest.
+type RefreshState struct {
+	// Next is the number of the next comic to check.
+	Next int `json:"next"`
+	// Passes counts the completed rotations over all comics.
+	Passes int `json:"passes"`
+}
+
+// RefreshResult counts what a refresh did.
+type RefreshResult struct {
+	Checked int
+	Changed int
+	Failed  int
+	// Wrapped is set when the refresh reached the last comic, completing a rotation.
+	Wrapped bool
+}
+
+func RefreshStatePath(indexFile string) string {
+	return filepath.Join(filepath.Dir(indexFile), "refresh.json")
+}
+
+func ReadRefreshState(indexFile string) (RefreshState, error) {
+	var state RefreshState
+	data, err := os.ReadFile(RefreshStatePath(indexFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, fmt.Errorf("failed to read refresh state: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to decode refresh state: %v", err)
+	}
+	return state, nil
+}
+
+func writeRefreshState(indexFile string, state RefreshState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode refresh state: %v", err)
+	}
+	return files.WriteAtomic(RefreshStatePath(indexFile), data)
+}
+
+// RefreshComics fetches up to count stored comics again, continuing the
+// rotation where the last refresh stopped, and saves and reindexes the ones
+// whose upstream metadata changed. Fetches are at least interval apart, so a
+// refresh does not compete with the crawl for new comics.
+func RefreshComics(store Store, indexFile string, fetcher ComicFetcher, count int, interval time.Duration) (RefreshResult, error) {
+	var result RefreshResult
+	state, err := ReadRefreshState(indexFile)
+	if err != nil {
+		return result, err
+	}
+	_, existing, err := store.LastNum()
+	if err != nil {
+		return result, fmt.Errorf("failed to read comic numbers: %v", err)
+	}
+	nums := make([]int, 0, len(existing))
+	for num := range existing {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	if len(nums) == 0 {
+		return result, nil
+	}
+	if count > len(nums) {
+		count = len(nums)
+	}
+
+	start := sort.SearchInts(nums, state.Next)
+	var limiter <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	var changed, previous, unmirrored []ComicKeywords
+	for i := 0; i < count; i++ {
+		num := nums[(start+i)%len(nums)]
+		if limiter != nil && i > 0 {
+			<-limiter
+		}
+
+		stored, err := store.Get(num)
+		if err != nil {
+			return result, fmt.Errorf("failed to load comic %d: %v", num, err)
+		}
+		fetched, err := fetcher.FetchComic(num)
+		result.Checked++
+		if err != nil {
+			log.Printf("Failed to refresh comic %d: %v", num, err)
+			result.Failed++
+			continue
+		}
+
+		fresh := NewComicKeywords(*fetched)
+		if metadataHash(fresh) == metadataHash(*stored) {
+			// Images that were evicted or never mirrored are filled in as the crawl passes by.
+			if Images != nil && stored.Img != "" && !Images.Has(stored.ImageHash) {
+				unmirrored = append(unmirrored, *stored)
+			}
+			continue
+		}
+		// A mirrored image stays valid as long as the comic points at the same image.
+		if fresh.Img == stored.Img && (Images == nil || Images.Has(stored.ImageHash)) {
+			fresh.ImageHash, fresh.ImageSize = stored.ImageHash, stored.ImageSize
+			fresh.ImageWidth, fresh.ImageHeight, fresh.ImageDHash = stored.ImageWidth, stored.ImageHeight, stored.ImageDHash
+		} else if Images != nil {
+			if err := mirrorImage(Images, &fresh); err != nil {
+				log.Printf("Failed to mirror image of comic %d: %v", num, err)
+			}
+		}
+		changed = append(changed, fresh)
+		previous = append(previous, *stored)
+	}
+
+	if len(changed) > 0 {
+		if err := commitComics(store, indexFile, changed); err != nil {
+			return result, err
+		}
+		if Revisions != nil {
+			// The versions they replace become the baseline of comics changing for the first time.
+			if _, err := Revisions.Seed(previous...); err != nil {
+				return result, fmt.Errorf("failed to record revisions: %v", err)
+			}
+			if _, err := Revisions.Record(time.Now(), changed...); err != nil {
+				return result, fmt.Errorf("failed to record revisions: %v", err)
+			}
+		}
+	}
+	result.Changed = len(changed)
+
+	var mirrored []ComicKeywords
+	for i := range unmirrored {
+		if err := mirrorImage(Images, &unmirrored[i]); err != nil {
+			log.Printf("Failed to mirror image of comic %d: %v", unmirrored[i].Num, err)
+			continue
+		}
+		mirrored = append(mirrored, unmirrored[i])
+	}
+	if err := saveImageDetails(store, indexFile, mirrored); err != nil {
+		return result, err
+	}
+
+	next := start + count
+	if next >= len(nums) {
+		result.Wrapped = true
+		state.Passes++
+		next %= len(nums)
+	}
+	state.Next = nums[next]
+	return result, writeRefreshState(indexFile, state)
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
+)
+
+func TestRefreshComics(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+	Revisions = NewRevisionLog(filepath.Join(dir, "revisions.ndjson"))
+	defer func() { Revisions = nil }()
+
+	stored := []ComicKeywords{
+		fetchedComic(t, 1, "Barrel", "boy in a barrel", ""),
+		fetchedComic(t, 2, "Petit Trees", "trees", ""),
+		fetchedComic(t, 3, "Island", "sand", ""),
+	}
+	stored[1].ImageHash = "mirrored"
+	if err := commitComics(store, indexFile, stored); err != nil {
+		t.Fatalf("Failed to commit comics: %v", err)
+	}
+
+	fixed := fetchedComic(t, 2, "Petit Trees", "trees and a raccoon", "")
+	fetcher := fakeFetcher{}
+	for _, comic := range []ComicKeywords{stored[0], fixed, stored[2]} {
+		upstream, err := decodeRaw(comic)
+		if err != nil {
+			t.Fatalf("Failed to decode comic: %v", err)
+		}
+		upstream.Raw = comic.Raw
+		fetcher[comic.Num] = upstream
+	}
+	// Upstream JSON with its keys in another order is not a change.
+	unchanged := fetcher[3]
+	var members map[string]interface{}
+	if err := json.Unmarshal(unchanged.Raw, &members); err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := json.Marshal(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged.Raw = reordered
+	fetcher[3] = unchanged
+
+	result, err := RefreshComics(store, indexFile, fetcher, 2, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if result.Checked != 2 || result.Changed != 1 || result.Failed != 0 || result.Wrapped {
+		t.Errorf("Unexpected result of the first batch: %+v", result)
+	}
+
+	comic, err := store.Get(2)
+	if err != nil {
+		t.Fatalf("Failed to get comic: %v", err)
+	}
+	if !contains(comic.Keywords, "raccoon") || comic.ImageHash != "mirrored" {
+		t.Errorf("Expected the fix with the mirrored image kept, got %+v", comic)
+	}
+	index, err := LoadIndex(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if len(index.Terms["raccoon"]) != 1 || index.Terms["raccoon"][0].ID != 2 {
+		t.Errorf("Expected the fix to be indexed, got %v", index.Terms["raccoon"])
+	}
+	if ok, err := Verify(store, indexFile); !ok || err != nil {
+		t.Errorf("Expected the index to match the database, got %v, %v", ok, err)
+	}
+	if history, _ := Revisions.List(2); len(history) != 2 || !history[0].Estimated || contains(history[0].Comic.Keywords, "raccoon") {
+		t.Errorf("Expected the fix to be recorded after the stored version, got %+v", history)
+	}
+	if history, _ := Revisions.List(1); len(history) != 0 {
+		t.Errorf("Expected no revisions of an unchanged comic, got %d", len(history))
+	}
+
+	// The next batch continues with comic 3 and wraps around to comic 1.
+	delete(fetcher, 1)
+	result, err = RefreshComics(store, indexFile, fetcher, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if result.Checked != 2 || result.Changed != 0 || result.Failed != 1 || !result.Wrapped {
+		t.Errorf("Unexpected result of the second batch: %+v", result)
+	}
+	state, err := ReadRefreshState(indexFile)
+	if err != nil || state.Next != 2 || state.Passes != 1 {
+		t.Errorf("Expected the rotation to continue at comic 2 after one pass, got %+v, %v", state, err)
+	}
+}
+
+func TestRefreshMirrorsImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+	Images = images.NewMirror(filepath.Join(dir, "images"), 0)
+	defer func() { Images = nil }()
+
+	// Comic 1 is unchanged but its image is not mirrored; comic 2 changed its image.
+	stored := []ComicKeywords{fetchedComic(t, 1, "Barrel", "", ""), fetchedComic(t, 2, "Island", "", "")}
+	stored[0].Img = server.URL + "/barrel.png"
+	stored[1].Img, stored[1].ImageHash = server.URL+"/old.png", "evicted"
+	if err := commitComics(store, indexFile, stored); err != nil {
+		t.Fatalf("Failed to commit comics: %v", err)
+	}
+	fetcher := fakeFetcher{}
+	for _, comic := range stored {
+		upstream, err := decodeRaw(comic)
+		if err != nil {
+			t.Fatalf("Failed to decode comic: %v", err)
+		}
+		upstream.Img, upstream.Raw = comic.Img, comic.Raw
+		fetcher[comic.Num] = upstream
+	}
+	upstream := fetcher[2]
+	upstream.Img = server.URL + "/island.png"
+	fetcher[2] = upstream
+
+	result, err := RefreshComics(store, indexFile, fetcher, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if result.Changed != 1 {
+		t.Errorf("Expected only the new image to count as a change, got %+v", result)
+	}
+	for _, num := range []int{1, 2} {
+		comic, err := store.Get(num)
+		if err != nil {
+			t.Fatalf("Failed to get comic: %v", err)
+		}
+		if !Images.Has(comic.ImageHash) || comic.ImageWidth != 4 {
+			t.Errorf("Expected the image of comic %d to be mirrored, got %+v", num, comic)
+		}
+	}
+}
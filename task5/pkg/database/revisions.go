@@ -0,0 +1,381 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// Revisions, if set, records a revision of every comic saved by FlushComicData.
+var Revisions *RevisionLog
+
+// Revision is one distinct version of the metadata of a comic.
+type Revision struct {
+	Num     int       `json:"num"`
+	Fetched time.Time `json:"fetched"`
+	// Estimated is set when the fetch time is unknown and the publication
+	// date stands in for it, as for comics stored before revisions were kept.
+	Estimated bool          `json:"estimated,omitempty"`
+	Hash      string        `json:"hash"`
+	Comic     ComicKeywords `json:"comic"`
+}
+
+// RevisionLog keeps every distinct version of the metadata of every comic in
+// an append-only NDJSON file. Appends take an exclusive and reads a shared
+// lock on a lock file, like the JSON store.
+type RevisionLog struct {
+	path string
+
+	mu        sync.Mutex
+	revisions map[int][]Revision
+	offset    int64
+	file      os.FileInfo
+}
+
+func NewRevisionLog(path string) *RevisionLog {
+	return &RevisionLog{path: path}
+}
+
+// Record appends the comics as revisions fetched at the given time, except
+// those whose metadata equals that of their latest revision. It returns how
+// many revisions were added.
+func (l *RevisionLog) Record(fetched time.Time, comics ...ComicKeywords) (int, error) {
+	revisions := make([]Revision, 0, len(comics))
+	for _, comic := range comics {
+		revisions = append(revisions, Revision{Num: comic.Num, Fetched: fetched.UTC(), Comic: comic})
+	}
+	return l.record(revisions)
+}
+
+// Seed records the comics, as they were stored before a change, with their
+// publication date as the fetch time, unless their comic has a revision
+// already. Comics without a date, stored with the first schema version, get
+// the zero time. Comics are seeded as they change, so the log only grows with
+// the changes, and AsOf takes the others from the store. It returns how many
+// were recorded.
+func (l *RevisionLog) Seed(comics ...ComicKeywords) (int, error) {
+	seeds := make([]Revision, 0, len(comics))
+	for _, comic := range comics {
+		seeds = append(seeds, Revision{Num: comic.Num, Fetched: publishedAt(comic), Estimated: true, Comic: comic})
+	}
+	return l.record(seeds)
+}
+
+// record appends the revisions whose metadata differs from the latest
+// revision of their comic and returns how many were appended. Estimated
+// revisions are only appended for comics that have none yet.
+func (l *RevisionLog) record(revisions []Revision) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, err := LockExclusive(l.lockPath())
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	if err := l.refresh(true); err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	var added []Revision
+	latest := make(map[int]string)
+	for _, revision := range revisions {
+		revision.Hash = metadataHash(revision.Comic)
+		previous, ok := latest[revision.Num]
+		if history := l.revisions[revision.Num]; !ok && len(history) > 0 {
+			previous, ok = history[len(history)-1].Hash, true
+		}
+		if ok && (revision.Estimated || previous == revision.Hash) {
+			continue
+		}
+		// Local details of the image are not part of the upstream metadata.
+		revision.Comic.ImageHash, revision.Comic.ImageSize = "", 0
+		revision.Comic.ImageWidth, revision.Comic.ImageHeight, revision.Comic.ImageDHash = 0, 0, ""
+		if err := encoder.Encode(revision); err != nil {
+			return 0, fmt.Errorf("error encoding revision of comic %d: %v", revision.Num, err)
+		}
+		latest[revision.Num] = revision.Hash
+		added = append(added, revision)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return 0, fmt.Errorf("error opening revision log %s: %v", l.path, err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("error appending to %s: %v", l.path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("error syncing %s: %v", l.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return 0, fmt.Errorf("error closing %s: %v", l.path, err)
+	}
+
+	for _, revision := range added {
+		l.revisions[revision.Num] = append(l.revisions[revision.Num], revision)
+	}
+	l.offset += int64(buf.Len())
+	return len(added), nil
+}
+
+// List returns the revisions of a comic from the oldest to the newest.
+func (l *RevisionLog) List(num int) ([]Revision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.lockedRefresh(); err != nil {
+		return nil, err
+	}
+	return append([]Revision(nil), l.revisions[num]...), nil
+}
+
+// AsOf returns every comic as it was at the given time: the latest revision
+// fetched at or before it, or the stored comic if it has no revisions and was
+// published by then. Keywords are computed with the current analyzer, so the
+// comics can be searched like the live ones.
+func (l *RevisionLog) AsOf(store Store, at time.Time) (map[int]*ComicKeywords, error) {
+	stored, err := store.All()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load comics: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.lockedRefresh(); err != nil {
+		return nil, err
+	}
+	comics := make(map[int]*ComicKeywords)
+	for num, history := range l.revisions {
+		i := sort.Search(len(history), func(i int) bool {
+			return history[i].Fetched.After(at)
+		})
+		if i == 0 {
+			continue
+		}
+		comic := history[i-1].Comic
+		if upstream, err := decodeRaw(comic); err == nil {
+			comic.Keywords = words.NormalizeInput(upstream.Transcript + " " + upstream.Alt)
+		}
+		comics[num] = &comic
+	}
+	for num, comic := range stored {
+		if len(l.revisions[num]) == 0 && !publishedAt(*comic).After(at) {
+			comics[num] = comic
+		}
+	}
+	return comics, nil
+}
+
+func (l *RevisionLog) lockedRefresh() error {
+	lock, err := LockShared(l.lockPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return l.refresh(false)
+}
+
+// refresh reads the records appended since the last read. A torn record at
+// the end, left by a crash during an append, is skipped by readers and cut
+// off before the next append, which holds the exclusive lock.
+func (l *RevisionLog) refresh(truncate bool) error {
+	if l.revisions == nil {
+		l.revisions = make(map[int][]Revision)
+	}
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error opening revision log %s: %v", l.path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if l.file != nil && (!os.SameFile(l.file, info) || info.Size() < l.offset) {
+		// The log was replaced, for example by a restore.
+		l.revisions = make(map[int][]Revision)
+		l.offset = 0
+	}
+	l.file = info
+	if info.Size() == l.offset {
+		return nil
+	}
+	if _, err := file.Seek(l.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading revision log %s: %v", l.path, err)
+		}
+		if len(line) == 0 {
+			break
+		}
+		if line[len(line)-1] != '\n' {
+			if truncate {
+				if err := os.Truncate(l.path, l.offset); err != nil {
+					return fmt.Errorf("error truncating torn revision in %s: %v", l.path, err)
+				}
+			}
+			break
+		}
+		var revision Revision
+		if err := json.Unmarshal(line, &revision); err != nil {
+			return fmt.Errorf("corrupted revision in %s at offset %d", l.path, l.offset)
+		}
+		l.revisions[revision.Num] = append(l.revisions[revision.Num], revision)
+		l.offset += int64(len(line))
+	}
+	for _, history := range l.revisions {
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Fetched.Before(history[j].Fetched)
+		})
+	}
+	return nil
+}
+
+// publishedAt returns the publication date of a comic, or the zero time if
+// it has none.
+func publishedAt(comic ComicKeywords) time.Time {
+	published, _ := time.Parse("2006-01-02", comic.Date)
+	return published
+}
+
+func (l *RevisionLog) lockPath() string {
+	return l.path + ".lock"
+}
+
+// FieldDiff is a field that differs between two revisions.
+type FieldDiff struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	// Lines is a line diff for multi-line values: every line starts with
+	// "-" if it was removed, "+" if it was added and " " if it was kept.
+	Lines []string `json:"lines,omitempty"`
+}
+
+// DiffRevisions returns the fields of the metadata that differ between two revisions.
+func DiffRevisions(old, new Revision) []FieldDiff {
+	oldFields, newFields := revisionFields(old.Comic), revisionFields(new.Comic)
+	var diffs []FieldDiff
+	for i, field := range oldFields {
+		if field.value == newFields[i].value {
+			continue
+		}
+		diff := FieldDiff{Field: field.name, Old: field.value, New: newFields[i].value}
+		if strings.Contains(diff.Old, "\n") || strings.Contains(diff.New, "\n") {
+			diff.Lines = diffLines(strings.Split(diff.Old, "\n"), strings.Split(diff.New, "\n"))
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+type revisionField struct {
+	name, value string
+}
+
+func revisionFields(comic ComicKeywords) []revisionField {
+	var upstream models.Comic
+	if decoded, err := decodeRaw(comic); err == nil {
+		upstream = decoded
+	}
+	return []revisionField{
+		{"title", comic.Title},
+		{"safe_title", comic.SafeTitle},
+		{"date", comic.Date},
+		{"img", comic.Img},
+		{"link", comic.Link},
+		{"news", comic.News},
+		{"alt", upstream.Alt},
+		{"transcript", upstream.Transcript},
+		{"extra_parts", string(comic.ExtraParts)},
+	}
+}
+
+// diffLines returns a line diff of old and new based on their longest common subsequence.
+func diffLines(old, new []string) []string {
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			lines = append(lines, " "+old[i])
+			i++
+			j++
+		case i < len(old) && (j == len(new) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+old[i])
+			i++
+		default:
+			lines = append(lines, "+"+new[j])
+			j++
+		}
+	}
+	return lines
+}
+
+// metadataHash identifies the upstream metadata of a comic by its decoded
+// fields, leaving out the keywords, which change with the analyzer, the local
+// image details and how the upstream JSON happens to be formatted.
+func metadataHash(comic ComicKeywords) string {
+	upstream, _ := decodeRaw(comic)
+	data, _ := json.Marshal(struct {
+		Title, SafeTitle, Date, Link, News, Img, Alt, Transcript string
+		ExtraParts                                               json.RawMessage
+	}{comic.Title, comic.SafeTitle, comic.Date, comic.Link, comic.News, comic.Img, upstream.Alt, upstream.Transcript, comic.ExtraParts})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func decodeRaw(comic ComicKeywords) (models.Comic, error) {
+	var upstream models.Comic
+	if len(comic.Raw) == 0 {
+		return upstream, fmt.Errorf("comic %d has no stored JSON", comic.Num)
+	}
+	err := json.Unmarshal(comic.Raw, &upstream)
+	return upstream, err
+}
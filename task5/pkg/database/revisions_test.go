@@ -0,0 +1,119 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+)
+
+func fetchedComic(t *testing.T, num int, title, transcript, alt string) ComicKeywords {
+	upstream := models.Comic{Num: num, Title: title, Year: "2006", Month: "1", Day: "1", Transcript: transcript, Alt: alt}
+	raw, err := json.Marshal(upstream)
+	if err != nil {
+		t.Fatalf("Failed to encode comic: %v", err)
+	}
+	upstream.Raw = raw
+	return NewComicKeywords(upstream)
+}
+
+func TestRevisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revisions.ndjson")
+	revisions := NewRevisionLog(path)
+	store := NewJSONStore(filepath.Join(t.TempDir(), "database.json"))
+
+	original := fetchedComic(t, 1, "Barrel", "A boy in a barrel.\nHe floats.", "Barrel alt")
+	if err := store.Save([]ComicKeywords{original}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	if comics, err := revisions.AsOf(store, time.Now()); err != nil || len(comics) != 1 {
+		t.Fatalf("Expected the stored comic without revisions, got %d, %v", len(comics), err)
+	}
+	if seeded, err := revisions.Seed(original); err != nil || seeded != 1 {
+		t.Fatalf("Expected one seeded revision, got %d, %v", seeded, err)
+	}
+	if seeded, err := revisions.Seed(original); err != nil || seeded != 0 {
+		t.Fatalf("Expected seeding to happen once, got %d, %v", seeded, err)
+	}
+
+	fixed := fetchedComic(t, 1, "Barrel", "A boy in a barrel.\nHe drifts away.", "Barrel alt")
+	fixed.ImageHash = "local detail"
+	fixedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if added, err := revisions.Record(fixedAt, fixed); err != nil || added != 1 {
+		t.Fatalf("Expected the fix to be recorded, got %d, %v", added, err)
+	}
+	if added, err := revisions.Record(fixedAt.Add(time.Hour), fixed); err != nil || added != 0 {
+		t.Fatalf("Expected an unchanged comic not to be recorded, got %d, %v", added, err)
+	}
+
+	// A fresh log reads the same history from the file.
+	history, err := NewRevisionLog(path).List(1)
+	if err != nil {
+		t.Fatalf("Failed to list revisions: %v", err)
+	}
+	if len(history) != 2 || !history[0].Estimated || history[1].Estimated || !history[1].Fetched.Equal(fixedAt) {
+		t.Fatalf("Unexpected history: %+v", history)
+	}
+	if history[1].Comic.ImageHash != "" {
+		t.Errorf("Expected local image details to be left out of revisions")
+	}
+
+	changes := DiffRevisions(history[0], history[1])
+	if len(changes) != 1 || changes[0].Field != "transcript" {
+		t.Fatalf("Expected only the transcript to change, got %+v", changes)
+	}
+	wantLines := []string{" A boy in a barrel.", "-He floats.", "+He drifts away."}
+	if !reflect.DeepEqual(changes[0].Lines, wantLines) {
+		t.Errorf("Expected line diff %q, got %q", wantLines, changes[0].Lines)
+	}
+
+	if err := store.Save([]ComicKeywords{fixed}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	before, err := revisions.AsOf(store, fixedAt.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to load comics as of a time: %v", err)
+	}
+	after, err := revisions.AsOf(store, fixedAt)
+	if err != nil {
+		t.Fatalf("Failed to load comics as of a time: %v", err)
+	}
+	if !contains(before[1].Keywords, "float") || contains(before[1].Keywords, "drift") {
+		t.Errorf("Expected the original keywords before the fix, got %v", before[1].Keywords)
+	}
+	if !contains(after[1].Keywords, "drift") {
+		t.Errorf("Expected the fixed keywords after the fix, got %v", after[1].Keywords)
+	}
+	if early, _ := revisions.AsOf(store, time.Date(2005, 1, 1, 0, 0, 0, 0, time.UTC)); len(early) != 0 {
+		t.Errorf("Expected no comics before the publication date, got %d", len(early))
+	}
+}
+
+func TestRevisionLogTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revisions.ndjson")
+	revisions := NewRevisionLog(path)
+	if _, err := revisions.Record(time.Now(), fetchedComic(t, 1, "Barrel", "", "")); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	file.WriteString(`{"num": 2, "fetch`)
+	file.Close()
+
+	fresh := NewRevisionLog(path)
+	if _, err := fresh.Record(time.Now(), fetchedComic(t, 3, "Island", "", "")); err != nil {
+		t.Fatalf("Failed to record after a torn record: %v", err)
+	}
+	for num, want := range map[int]int{1: 1, 2: 0, 3: 1} {
+		history, err := NewRevisionLog(path).List(num)
+		if err != nil || len(history) != want {
+			t.Errorf("Expected %d revisions of comic %d, got %d, %v", want, num, len(history), err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
@@ -10,7 +11,7 @@ import (
 
 var (
 	comicsData  []ComicKeywords
-	searchIndex map[string][]int
+	searchIndex words.Index
 )
 
 func loadComicsData() {
@@ -24,13 +25,20 @@ func loadComicsData() {
 }
 
 func loadIndex() {
-	data, err := os.ReadFile("index.json")
-	if err != nil {
-		panic(err)
+	comics := make(map[int]*ComicKeywords, len(comicsData))
+	for i := range comicsData {
+		comics[comicsData[i].Num] = &comicsData[i]
 	}
-	if err := json.Unmarshal(data, &searchIndex); err != nil {
-		panic(err)
+	searchIndex = MakeIndex(comics)
+}
+
+// writeIndex writes searchIndex to a temporary file in the format chosen by name.
+func writeIndex(b *testing.B, name string) string {
+	indexFile := filepath.Join(b.TempDir(), name)
+	if err := words.NewIndexWriter(indexFile).Replace(searchIndex); err != nil {
+		b.Fatal(err)
 	}
+	return indexFile
 }
 
 func init() {
@@ -47,6 +55,55 @@ func BenchmarkSearchByIndex(b *testing.B) {
 	}
 }
 
+func BenchmarkSearchByBinaryIndex(b *testing.B) {
+	query := "I'm following your questions"
+	index, err := words.DecodeBinaryIndex(words.EncodeBinaryIndex(searchIndex))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = words.SearchIndex(query, index)
+	}
+}
+
+func BenchmarkSearchByMappedIndex(b *testing.B) {
+	query := "I'm following your questions"
+	index, err := words.OpenMappedIndex(writeIndex(b, "index.bin"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer index.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = words.SearchIndex(query, index)
+	}
+}
+
+func BenchmarkLoadJSONIndex(b *testing.B) {
+	indexFile := writeIndex(b, "index.json")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := words.LoadIndex(indexFile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadBinaryIndex(b *testing.B) {
+	indexFile := writeIndex(b, "index.bin")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := words.LoadIndex(indexFile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkLinearSearch(b *testing.B) {
 	query := "I'm following your questions"
 
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS comics (
+	num            INTEGER PRIMARY KEY,
+	img            TEXT NOT NULL,
+	keywords       TEXT NOT NULL,
+	schema_version INTEGER NOT NULL DEFAULT 0,
+	title          TEXT NOT NULL DEFAULT '',
+	safe_title     TEXT NOT NULL DEFAULT '',
+	date           TEXT NOT NULL DEFAULT '',
+	link           TEXT NOT NULL DEFAULT '',
+	news           TEXT NOT NULL DEFAULT '',
+	extra_parts    TEXT NOT NULL DEFAULT '',
+	raw            TEXT NOT NULL DEFAULT '',
+	image_hash     TEXT NOT NULL DEFAULT '',
+	image_size     INTEGER NOT NULL DEFAULT 0,
+	image_width    INTEGER NOT NULL DEFAULT 0,
+	image_height   INTEGER NOT NULL DEFAULT 0,
+	image_dhash    TEXT NOT NULL DEFAULT ''
+)`
+
+const sqliteComicColumns = `num, img, keywords, schema_version, title, safe_title, date, link, news, extra_parts, raw,
+	image_hash, image_size, image_width, image_height, image_dhash`
+
+// SQLiteStore keeps comics in an embedded SQLite database, one row per comic.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	// Other processes writing the same file are waited for like the file locks of the JSON store.
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, LockTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %v", path, err)
+	}
+	// A single connection serializes writers and avoids SQLITE_BUSY between them.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %v", path, err)
+	}
+	return &SQLiteStore{db: db, path: path}, nil
+}
+
+func (s *SQLiteStore) Save(comics []ComicKeywords) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO comics (` + sqliteComicColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, comic := range comics {
+		keywords, err := json.Marshal(comic.Keywords)
+		if err != nil {
+			return fmt.Errorf("failed to encode keywords of comic %d: %v", comic.Num, err)
+		}
+		_, err = stmt.Exec(comic.Num, comic.Img, string(keywords), comic.SchemaVersion, comic.Title, comic.SafeTitle,
+			comic.Date, comic.Link, comic.News, string(comic.ExtraParts), string(comic.Raw),
+			comic.ImageHash, comic.ImageSize, comic.ImageWidth, comic.ImageHeight, comic.ImageDHash)
+		if err != nil {
+			return fmt.Errorf("failed to insert comic %d: %v", comic.Num, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Get(num int) (*ComicKeywords, error) {
+	row := s.db.QueryRow(`SELECT `+sqliteComicColumns+` FROM comics WHERE num = ?`, num)
+	comic, err := scanComic(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrComicNotFound
+	}
+	return comic, err
+}
+
+func (s *SQLiteStore) All() (map[int]*ComicKeywords, error) {
+	rows, err := s.db.Query(`SELECT ` + sqliteComicColumns + ` FROM comics`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comics: %v", err)
+	}
+	defer rows.Close()
+
+	comicsMap := make(map[int]*ComicKeywords)
+	for rows.Next() {
+		comic, err := scanComic(rows)
+		if err != nil {
+			return nil, err
+		}
+		comicsMap[comic.Num] = comic
+	}
+	return comicsMap, rows.Err()
+}
+
+func (s *SQLiteStore) LastNum() (int, map[int]bool, error) {
+	rows, err := s.db.Query(`SELECT num FROM comics`)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query comic numbers: %v", err)
+	}
+	defer rows.Close()
+
+	maxNum := 0
+	existingNums := make(map[int]bool)
+	for rows.Next() {
+		var num int
+		if err := rows.Scan(&num); err != nil {
+			return 0, nil, fmt.Errorf("failed to read comic number: %v", err)
+		}
+		if num > maxNum {
+			maxNum = num
+		}
+		existingNums[num] = true
+	}
+	return maxNum, existingNums, rows.Err()
+}
+
+// SnapshotFiles returns a consistent copy of the database file made with VACUUM INTO, keyed by file name.
+func (s *SQLiteStore) SnapshotFiles() (map[string][]byte, error) {
+	copyPath := s.path + ".snapshot.tmp"
+	os.Remove(copyPath)
+	defer os.Remove(copyPath)
+
+	if _, err := s.db.Exec(`VACUUM INTO ?`, copyPath); err != nil {
+		return nil, fmt.Errorf("failed to copy sqlite database %s: %v", s.path, err)
+	}
+	data, err := os.ReadFile(copyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read copy of sqlite database: %v", err)
+	}
+	return map[string][]byte{filepath.Base(s.path): data}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanComic(row rowScanner) (*ComicKeywords, error) {
+	var (
+		comic                     ComicKeywords
+		keywords, extraParts, raw string
+	)
+	err := row.Scan(&comic.Num, &comic.Img, &keywords, &comic.SchemaVersion, &comic.Title, &comic.SafeTitle,
+		&comic.Date, &comic.Link, &comic.News, &extraParts, &raw,
+		&comic.ImageHash, &comic.ImageSize, &comic.ImageWidth, &comic.ImageHeight, &comic.ImageDHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(keywords), &comic.Keywords); err != nil {
+		return nil, fmt.Errorf("failed to decode keywords of comic %d: %v", comic.Num, err)
+	}
+	if extraParts != "" {
+		comic.ExtraParts = json.RawMessage(extraParts)
+	}
+	if raw != "" {
+		comic.Raw = json.RawMessage(raw)
+	}
+	return &comic, nil
+}
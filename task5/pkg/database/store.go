@@ -0,0 +1,38 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+var ErrComicNotFound = errors.New("comic not found")
+
+// Store is a storage backend for processed comics.
+type Store interface {
+	// Save persists a batch of comics.
+	Save(comics []ComicKeywords) error
+	// Get returns a single comic by its number.
+	Get(num int) (*ComicKeywords, error)
+	// All returns every stored comic keyed by its number.
+	All() (map[int]*ComicKeywords, error)
+	// LastNum returns the highest stored comic number and the set of stored numbers.
+	LastNum() (int, map[int]bool, error)
+	Close() error
+}
+
+// Open opens the store for the given driver. An empty driver selects the JSON file backend.
+func Open(driver, path string) (Store, error) {
+	switch driver {
+	case "", DriverJSON:
+		return NewJSONStore(path), nil
+	case DriverSQLite:
+		return NewSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
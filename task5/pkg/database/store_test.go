@@ -0,0 +1,159 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+func TestStores(t *testing.T) {
+	testCases := []struct {
+		name   string
+		driver string
+		file   string
+	}{
+		{name: "JSON", driver: DriverJSON, file: "database.json"},
+		{name: "SQLite", driver: DriverSQLite, file: "database.db"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := Open(tc.driver, filepath.Join(t.TempDir(), tc.file))
+			if err != nil {
+				t.Fatalf("Failed to open store: %v", err)
+			}
+			defer store.Close()
+
+			if last, nums, err := store.LastNum(); err != nil || last != 0 || len(nums) != 0 {
+				t.Fatalf("Expected empty store, got last=%d nums=%v err=%v", last, nums, err)
+			}
+
+			comics := []ComicKeywords{
+				{Num: 1, Img: "one.png", Keywords: []string{"barrel"}},
+				{Num: 3, Img: "three.png", Keywords: []string{"island", "sand"},
+					ImageHash: "ab12", ImageSize: 1024, ImageWidth: 640, ImageHeight: 480, ImageDHash: "00ff00ff00ff00ff"},
+			}
+			if err := store.Save(comics); err != nil {
+				t.Fatalf("Failed to save comics: %v", err)
+			}
+
+			last, nums, err := store.LastNum()
+			if err != nil {
+				t.Fatalf("Failed to read last comic number: %v", err)
+			}
+			if last != 3 || !nums[1] || !nums[3] || nums[2] {
+				t.Errorf("Unexpected numbers: last=%d nums=%v", last, nums)
+			}
+
+			comic, err := store.Get(3)
+			if err != nil {
+				t.Fatalf("Failed to get comic: %v", err)
+			}
+			if comic.Img != "three.png" || len(comic.Keywords) != 2 ||
+				comic.ImageHash != "ab12" || comic.ImageSize != 1024 || comic.ImageWidth != 640 || comic.ImageHeight != 480 ||
+				comic.ImageDHash != "00ff00ff00ff00ff" {
+				t.Errorf("Unexpected comic: %+v", comic)
+			}
+
+			if _, err := store.Get(2); !errors.Is(err, ErrComicNotFound) {
+				t.Errorf("Expected ErrComicNotFound, got %v", err)
+			}
+
+			all, err := store.All()
+			if err != nil {
+				t.Fatalf("Failed to load all comics: %v", err)
+			}
+			if len(all) != 2 {
+				t.Errorf("Expected 2 comics, got %d", len(all))
+			}
+		})
+	}
+}
+
+func TestJSONStoreLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	store := NewJSONStore(path)
+
+	for num := 1; num <= maxSegments; num++ {
+		if err := store.Save([]ComicKeywords{{Num: num, Img: "first.png"}}); err != nil {
+			t.Fatalf("Failed to save comic %d: %v", num, err)
+		}
+	}
+	if err := store.Save([]ComicKeywords{{Num: 2, Img: "second.png"}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+
+	segment := files.SegmentName(store.path, 1)
+	file, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	file.WriteString(`{"num":99,"img":"to`)
+	file.Close()
+
+	reopened := NewJSONStore(path)
+	all, err := reopened.All()
+	if err != nil {
+		t.Fatalf("Failed to load comics: %v", err)
+	}
+	if len(all) != maxSegments {
+		t.Errorf("Expected %d comics, got %d", maxSegments, len(all))
+	}
+	if all[2].Img != "second.png" {
+		t.Errorf("Expected later record to win, got %q", all[2].Img)
+	}
+	if _, ok := all[99]; ok {
+		t.Errorf("Expected torn record to be dropped")
+	}
+
+	if err := reopened.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if segments, _ := reopened.segments(); len(segments) != 0 {
+		t.Errorf("Expected no segments after compaction, got %v", segments)
+	}
+	if all, _ := NewJSONStore(path).All(); len(all) != maxSegments || all[2].Img != "second.png" {
+		t.Errorf("Unexpected comics after compaction: %v", all)
+	}
+}
+
+func TestJSONStoreTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	store := NewJSONStore(path)
+	if err := store.Save([]ComicKeywords{{Num: 1, Img: "one.png"}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	segment := files.SegmentName(path, 1)
+	if err := files.AppendSynced(segment, []byte(`{"num":99,"img":"to`)); err != nil {
+		t.Fatal(err)
+	}
+	torn, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers hold the shared lock only, so they skip the torn record
+	// without cutting it off.
+	reader := NewJSONStore(path)
+	if _, err := reader.Get(99); err != ErrComicNotFound {
+		t.Errorf("Expected the torn record to be skipped, got %v", err)
+	}
+	if info, err := os.Stat(segment); err != nil || info.Size() != torn.Size() {
+		t.Errorf("Expected a reader to leave the segment alone, got %v, %v", info.Size(), err)
+	}
+
+	// A writer cuts it off before appending, also when its view is current.
+	if err := reader.Save([]ComicKeywords{{Num: 2, Img: "two.png"}}); err != nil {
+		t.Fatalf("Failed to save comic: %v", err)
+	}
+	all, err := NewJSONStore(path).All()
+	if err != nil {
+		t.Fatalf("Failed to load comics: %v", err)
+	}
+	if len(all) != 2 || all[2] == nil || all[2].Img != "two.png" {
+		t.Errorf("Expected comics 1 and 2, got %v", all)
+	}
+}
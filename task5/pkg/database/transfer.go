@@ -0,0 +1,239 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var csvHeader = []string{
+	"schema_version", "num", "title", "safe_title", "date", "link", "news", "img", "keywords", "extra_parts", "raw",
+}
+
+// ImportResult counts what happened to the records of an import.
+type ImportResult struct {
+	Imported   int
+	Duplicates int
+	Invalid    int
+	// Errors describe the invalid records.
+	Errors []string
+}
+
+// Export writes every comic in number order in the given format and returns how many were written.
+func Export(store Store, w io.Writer, format string) (int, error) {
+	comics, err := LoadAllComics(store)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load comics: %v", err)
+	}
+	nums := make([]int, 0, len(comics))
+	for num := range comics {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	switch format {
+	case FormatNDJSON:
+		buffered := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buffered)
+		for _, num := range nums {
+			if err := encoder.Encode(comics[num]); err != nil {
+				return 0, fmt.Errorf("failed to encode comic %d: %v", num, err)
+			}
+		}
+		return len(nums), buffered.Flush()
+
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		for _, num := range nums {
+			comic := comics[num]
+			record := []string{
+				strconv.Itoa(comic.SchemaVersion), strconv.Itoa(comic.Num), comic.Title, comic.SafeTitle,
+				comic.Date, comic.Link, comic.News, comic.Img, strings.Join(comic.Keywords, " "),
+				string(comic.ExtraParts), string(comic.Raw),
+			}
+			if err := writer.Write(record); err != nil {
+				return 0, err
+			}
+		}
+		writer.Flush()
+		return len(nums), writer.Error()
+
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Import reads comics in the given format, skips invalid records and comics
+// that are already stored or repeated in the input, saves the rest and
+// rebuilds the index. Commits are held off for the whole import.
+func Import(store Store, indexFile string, r io.Reader, format string) (ImportResult, error) {
+	var result ImportResult
+	// Hold off commits until the index covers the imported comics.
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		return result, err
+	}
+	defer lock.Unlock()
+
+	_, existing, err := store.LastNum()
+	if err != nil {
+		return result, fmt.Errorf("failed to read comic numbers: %v", err)
+	}
+	seen := make(map[int]bool)
+
+	var comics []ComicKeywords
+	accept := func(line int, comic ComicKeywords, err error) {
+		if err == nil {
+			err = validateComic(&comic)
+		}
+		if err != nil {
+			result.Invalid++
+			result.Errors = append(result.Errors, fmt.Sprintf("record %d: %v", line, err))
+			return
+		}
+		if existing[comic.Num] || seen[comic.Num] {
+			result.Duplicates++
+			return
+		}
+		seen[comic.Num] = true
+		comics = append(comics, comic)
+	}
+
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var comic ComicKeywords
+			err := json.Unmarshal(scanner.Bytes(), &comic)
+			accept(line, comic, err)
+		}
+		if err := scanner.Err(); err != nil {
+			return result, fmt.Errorf("failed to read input: %v", err)
+		}
+
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err != nil {
+			return result, fmt.Errorf("failed to read CSV header: %v", err)
+		}
+		columns := make(map[string]int)
+		for i, name := range header {
+			columns[name] = i
+		}
+		if _, ok := columns["num"]; !ok {
+			return result, errors.New("CSV header has no num column")
+		}
+		for line := 2; ; line++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				accept(line, ComicKeywords{}, err)
+				continue
+			}
+			comic, err := comicFromCSV(columns, record)
+			accept(line, comic, err)
+		}
+
+	default:
+		return result, fmt.Errorf("unknown import format %q", format)
+	}
+
+	if len(comics) == 0 {
+		return result, nil
+	}
+	if err := store.Save(comics); err != nil {
+		return result, fmt.Errorf("failed to save comics: %v", err)
+	}
+	result.Imported = len(comics)
+	return result, buildIndex(store, indexFile)
+}
+
+func comicFromCSV(columns map[string]int, record []string) (ComicKeywords, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var comic ComicKeywords
+	num, err := strconv.Atoi(field("num"))
+	if err != nil {
+		return comic, fmt.Errorf("invalid num %q", field("num"))
+	}
+	comic.Num = num
+	if version := field("schema_version"); version != "" {
+		if comic.SchemaVersion, err = strconv.Atoi(version); err != nil {
+			return comic, fmt.Errorf("invalid schema_version %q", version)
+		}
+	}
+	comic.Title = field("title")
+	comic.SafeTitle = field("safe_title")
+	comic.Date = field("date")
+	comic.Link = field("link")
+	comic.News = field("news")
+	comic.Img = field("img")
+	comic.Keywords = strings.Fields(field("keywords"))
+	if extraParts := field("extra_parts"); extraParts != "" {
+		comic.ExtraParts = json.RawMessage(extraParts)
+	}
+	if raw := field("raw"); raw != "" {
+		comic.Raw = json.RawMessage(raw)
+	}
+	return comic, nil
+}
+
+// validateComic checks an imported comic and fills in keywords from its
+// upstream JSON when they are missing.
+func validateComic(comic *ComicKeywords) error {
+	if comic.Num <= 0 {
+		return fmt.Errorf("invalid num %d", comic.Num)
+	}
+	if comic.Img == "" {
+		return fmt.Errorf("comic %d has no img", comic.Num)
+	}
+	if comic.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("comic %d has unsupported schema version %d", comic.Num, comic.SchemaVersion)
+	}
+	for _, raw := range []json.RawMessage{comic.ExtraParts, comic.Raw} {
+		if len(raw) > 0 && !json.Valid(raw) {
+			return fmt.Errorf("comic %d has invalid embedded JSON", comic.Num)
+		}
+	}
+
+	if len(comic.Keywords) == 0 && len(comic.Raw) > 0 {
+		var upstream models.Comic
+		if err := json.Unmarshal(comic.Raw, &upstream); err != nil {
+			return fmt.Errorf("comic %d has invalid raw JSON: %v", comic.Num, err)
+		}
+		if upstream.Num != comic.Num {
+			return fmt.Errorf("comic %d has raw JSON of comic %d", comic.Num, upstream.Num)
+		}
+		comic.Keywords = words.NormalizeInput(upstream.Transcript + " " + upstream.Alt)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	comics := []ComicKeywords{
+		{SchemaVersion: SchemaVersion, Num: 1, Title: "Barrel, Part 1", Img: "one.png", Keywords: []string{"barrel", "boy"}},
+		{Num: 2, Img: "two.png", Keywords: []string{"petit"}, Raw: []byte(`{"num":2,"alt":"\"quoted\""}`)},
+	}
+
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			source := NewJSONStore(filepath.Join(t.TempDir(), "database.json"))
+			if err := source.Save(comics); err != nil {
+				t.Fatalf("Failed to save comics: %v", err)
+			}
+
+			var buf bytes.Buffer
+			exported, err := Export(source, &buf, format)
+			if err != nil || exported != 2 {
+				t.Fatalf("Expected 2 exported comics, got %d, %v", exported, err)
+			}
+
+			dir := t.TempDir()
+			target := NewJSONStore(filepath.Join(dir, "database.json"))
+			indexFile := filepath.Join(dir, "index.json")
+			result, err := Import(target, indexFile, bytes.NewReader(buf.Bytes()), format)
+			if err != nil {
+				t.Fatalf("Failed to import: %v", err)
+			}
+			if result.Imported != 2 || result.Duplicates != 0 || result.Invalid != 0 {
+				t.Errorf("Unexpected import result: %+v", result)
+			}
+
+			imported, err := target.All()
+			if err != nil {
+				t.Fatalf("Failed to load imported comics: %v", err)
+			}
+			for _, comic := range comics {
+				if !reflect.DeepEqual(*imported[comic.Num], comic) {
+					t.Errorf("Expected %+v, got %+v", comic, *imported[comic.Num])
+				}
+			}
+			if consistent, err := Verify(target, indexFile); err != nil || !consistent {
+				t.Errorf("Expected index to be rebuilt, got %v, %v", consistent, err)
+			}
+
+			result, err = Import(target, indexFile, bytes.NewReader(buf.Bytes()), format)
+			if err != nil || result.Imported != 0 || result.Duplicates != 2 {
+				t.Errorf("Expected all records to be duplicates, got %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	input := strings.Join([]string{
+		`{"num":3,"img":"three.png","raw":{"num":3,"transcript":"islands"}}`,
+		`{"num":0,"img":"zero.png"}`,
+		`{"num":4}`,
+		`not json`,
+		`{"num":3,"img":"again.png"}`,
+	}, "\n")
+
+	dir := t.TempDir()
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+	result, err := Import(store, filepath.Join(dir, "index.json"), strings.NewReader(input), FormatNDJSON)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if result.Imported != 1 || result.Invalid != 3 || result.Duplicates != 1 || len(result.Errors) != 3 {
+		t.Errorf("Unexpected import result: %+v", result)
+	}
+
+	comic, err := store.Get(3)
+	if err != nil {
+		t.Fatalf("Failed to get comic: %v", err)
+	}
+	if !reflect.DeepEqual(comic.Keywords, []string{"island"}) {
+		t.Errorf("Expected keywords from raw JSON, got %v", comic.Keywords)
+	}
+}
+
+func TestImportHoldsCommitLock(t *testing.T) {
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.json")
+	store := NewJSONStore(filepath.Join(dir, "database.json"))
+
+	lock, err := LockExclusive(commitLockPath(indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := `{"schema_version":1,"num":1,"img":"one.png","keywords":["barrel"]}` + "\n"
+	if _, err := Import(store, indexFile, strings.NewReader(input), FormatNDJSON); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked during a commit, got %v", err)
+	}
+	if all, _ := store.All(); len(all) != 0 {
+		t.Errorf("Expected nothing imported during a commit, got %v", all)
+	}
+	lock.Unlock()
+
+	if result, err := Import(store, indexFile, strings.NewReader(input), FormatNDJSON); err != nil || result.Imported != 1 {
+		t.Errorf("Expected 1 imported comic, got %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package files has the durable file writes and the segment log naming
+// shared by the comic store and the index.
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WriteAtomic replaces path with data through a synced temporary file and
+// syncs the directory, so that the new contents are durable once it returns.
+func WriteAtomic(path string, data []byte) error {
+	tempFile := path + ".tmp"
+	file, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", tempFile, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing to %s: %v", tempFile, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing %s: %v", tempFile, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %v", tempFile, err)
+	}
+
+	if err := os.Rename(tempFile, path); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %v", tempFile, path, err)
+	}
+	return SyncDir(filepath.Dir(path))
+}
+
+// AppendSynced appends data to path, creating it, and syncs it.
+func AppendSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error appending to %s: %v", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// SyncDir makes the creation, renaming and removal of the files of a
+// directory durable.
+func SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory %s: %v", dir, err)
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory %s: %v", dir, err)
+	}
+	return nil
+}
+
+// Segments returns the NDJSON log segments of the base file at path, oldest
+// first. Segments are named after the base file and a sequence number.
+func Segments(path string) ([]string, error) {
+	segments, err := filepath.Glob(path + ".*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return SegmentSeq(segments[i]) < SegmentSeq(segments[j])
+	})
+	return segments, nil
+}
+
+// SegmentName returns the name of the segment of path with a sequence number.
+func SegmentName(path string, seq int) string {
+	return fmt.Sprintf("%s.%06d.ndjson", path, seq)
+}
+
+// SegmentSeq returns the sequence number of a segment.
+func SegmentSeq(segment string) int {
+	var seq int
+	name := strings.TrimSuffix(segment, ".ndjson")
+	fmt.Sscanf(name[strings.LastIndex(name, ".")+1:], "%d", &seq)
+	return seq
+}
+
+// ActiveSegment returns the segment to append to given the existing ones:
+// the newest, or a new one once the newest has reached maxSize.
+func ActiveSegment(path string, segments []string, maxSize int64) string {
+	if len(segments) == 0 {
+		return SegmentName(path, 1)
+	}
+	active := segments[len(segments)-1]
+	if info, err := os.Stat(active); err == nil && info.Size() >= maxSize {
+		return SegmentName(path, SegmentSeq(active)+1)
+	}
+	return active
+}
@@ -0,0 +1,51 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if got := ActiveSegment(path, nil, 4); got != SegmentName(path, 1) {
+		t.Errorf("Expected the first segment, got %s", got)
+	}
+
+	for _, seq := range []int{10, 2, 1} {
+		if err := AppendSynced(SegmentName(path, seq), []byte("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, err := Segments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{SegmentName(path, 1), SegmentName(path, 2), SegmentName(path, 10)}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("Expected %v, got %v", expected, segments)
+	}
+
+	if got := ActiveSegment(path, segments, 4); got != SegmentName(path, 10) {
+		t.Errorf("Expected to append to the newest segment, got %s", got)
+	}
+	if got := ActiveSegment(path, segments, 3); got != SegmentName(path, 11) {
+		t.Errorf("Expected a new segment after a full one, got %s", got)
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	for _, data := range []string{"old", "new"} {
+		if err := WriteAtomic(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("Expected the new contents, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left, got %v", err)
+	}
+}
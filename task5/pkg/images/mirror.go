@@ -0,0 +1,257 @@
+// Package images keeps local copies of comic images in a content-addressed directory.
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// maxImageSize bounds a single download so a bad response cannot fill the disk.
+const maxImageSize = 32 << 20
+
+// Info describes a mirrored image.
+type Info struct {
+	Hash   string
+	Size   int64
+	Width  int
+	Height int
+	// DHash is the perceptual hash of the image, see DHash.
+	DHash uint64
+}
+
+// Mirror stores images under dir as <first two hex digits>/<sha256>. Identical
+// images share a file. When the total size goes over MaxSize, the least
+// recently used files are evicted; file modification times serve as the
+// use times, so they survive restarts and are shared between processes.
+type Mirror struct {
+	dir string
+	// MaxSize is the cap of the total size of the mirror in bytes. Zero means no cap.
+	MaxSize int64
+
+	client http.Client
+	mu     sync.Mutex
+	// size is the total size of the mirror as of the last scan plus what was
+	// stored since, if sized is set. Files stored by other processes are only
+	// counted by the next scan, which eviction starts once size is over MaxSize.
+	size  int64
+	sized bool
+}
+
+func NewMirror(dir string, maxSize int64) *Mirror {
+	return &Mirror{
+		dir:     dir,
+		MaxSize: maxSize,
+		client:  http.Client{Timeout: time.Minute},
+	}
+}
+
+// Download fetches the image at url and stores it.
+func (m *Mirror) Download(url string) (Info, error) {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return Info{}, fmt.Errorf("error fetching image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("received non-200 response status for image: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return Info{}, fmt.Errorf("error reading image: %v", err)
+	}
+	if len(data) > maxImageSize {
+		return Info{}, fmt.Errorf("image %s is larger than %d bytes", url, maxImageSize)
+	}
+	return m.Store(data)
+}
+
+// Store adds an image to the mirror and returns its description.
+func (m *Mirror) Store(data []byte) (Info, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("error decoding image: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("error decoding image: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	info := Info{
+		Hash:   hex.EncodeToString(sum[:]),
+		Size:   int64(len(data)),
+		Width:  config.Width,
+		Height: config.Height,
+		DHash:  DHash(img),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path := m.path(info.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return info, touch(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Info{}, fmt.Errorf("error creating image directory: %v", err)
+	}
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return Info{}, fmt.Errorf("error writing image: %v", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return Info{}, fmt.Errorf("error storing image: %v", err)
+	}
+	return info, m.evict(path, info.Size)
+}
+
+// Open opens the stored image with the given hash and marks it as used.
+// It returns an error satisfying os.IsNotExist if the image is not stored.
+func (m *Mirror) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, os.ErrNotExist
+	}
+	path := m.path(hash)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	touch(path)
+	return file, nil
+}
+
+// DHash computes the perceptual hash of a stored image.
+func (m *Mirror) DHash(hash string) (uint64, error) {
+	file, err := m.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding image %s: %v", hash, err)
+	}
+	return DHash(img), nil
+}
+
+// Has reports whether the image with the given hash is stored.
+func (m *Mirror) Has(hash string) bool {
+	if !validHash(hash) {
+		return false
+	}
+	_, err := os.Stat(m.path(hash))
+	return err == nil
+}
+
+// Size returns the total size of the stored images and thumbnails.
+func (m *Mirror) Size() (int64, error) {
+	files, err := m.files()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	return total, nil
+}
+
+type storedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict accounts for a file of size added that was just stored and, if the
+// mirror is over its cap, removes the least recently used images and
+// thumbnails until it fits. The file just stored is kept even if it alone is
+// over the cap. The directory is only scanned when the cap may be exceeded.
+func (m *Mirror) evict(keep string, added int64) error {
+	if m.MaxSize <= 0 {
+		return nil
+	}
+	if m.sized {
+		m.size += added
+		if m.size <= m.MaxSize {
+			return nil
+		}
+	}
+	files, err := m.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if total <= m.MaxSize {
+			break
+		}
+		if file.path == keep {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error evicting image: %v", err)
+		}
+		total -= file.size
+	}
+	m.size, m.sized = total, true
+	return nil
+}
+
+func (m *Mirror) files() ([]storedFile, error) {
+	paths, err := filepath.Glob(filepath.Join(m.dir, "??", "*"))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]storedFile, 0, len(paths))
+	for _, path := range paths {
+		if !validStoredName(filepath.Base(path)) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, storedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (m *Mirror) path(hash string) string {
+	return filepath.Join(m.dir, hash[:2], hash)
+}
+
+func touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,127 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMirrorStore(t *testing.T) {
+	mirror := NewMirror(t.TempDir(), 0)
+	data := encodePNG(t, 30, 20)
+
+	info, err := mirror.Store(data)
+	if err != nil {
+		t.Fatalf("Failed to store image: %v", err)
+	}
+	if info.Width != 30 || info.Height != 20 || info.Size != int64(len(data)) || len(info.Hash) != 64 {
+		t.Errorf("Unexpected image info: %+v", info)
+	}
+	again, err := mirror.Store(data)
+	if err != nil || again != info {
+		t.Errorf("Expected the same image to be stored once, got %+v, %v", again, err)
+	}
+	if size, _ := mirror.Size(); size != info.Size {
+		t.Errorf("Expected mirror size %d, got %d", info.Size, size)
+	}
+
+	file, err := mirror.Open(info.Hash)
+	if err != nil {
+		t.Fatalf("Failed to open image: %v", err)
+	}
+	stored, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Stored image differs from the original")
+	}
+
+	if _, err := mirror.Open("../../etc/passwd"); !os.IsNotExist(err) {
+		t.Errorf("Expected an invalid hash to be not found, got %v", err)
+	}
+	if _, err := mirror.Store([]byte("not an image")); err == nil {
+		t.Errorf("Expected an error for data that is not an image")
+	}
+}
+
+func TestMirrorEvictsLeastRecentlyUsed(t *testing.T) {
+	images := [][]byte{encodePNG(t, 10, 10), encodePNG(t, 11, 10), encodePNG(t, 12, 10)}
+	mirror := NewMirror(t.TempDir(), 0)
+
+	var infos []Info
+	for i, data := range images[:2] {
+		info, err := mirror.Store(data)
+		if err != nil {
+			t.Fatalf("Failed to store image: %v", err)
+		}
+		// Make the use times distinct and the first image the older one.
+		used := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(mirror.path(info.Hash), used, used)
+		infos = append(infos, info)
+	}
+	// Using the first image makes the second the least recently used.
+	file, err := mirror.Open(infos[0].Hash)
+	if err != nil {
+		t.Fatalf("Failed to open image: %v", err)
+	}
+	file.Close()
+
+	mirror.MaxSize = int64(len(images[0]) + len(images[2]))
+	third, err := mirror.Store(images[2])
+	if err != nil {
+		t.Fatalf("Failed to store image: %v", err)
+	}
+	if !mirror.Has(infos[0].Hash) || mirror.Has(infos[1].Hash) || !mirror.Has(third.Hash) {
+		t.Errorf("Expected only the least recently used image to be evicted")
+	}
+
+	// The running total follows later stores without scanning the directory.
+	mirror.MaxSize = 1 << 20
+	if _, err := mirror.Store(images[1]); err != nil {
+		t.Fatalf("Failed to store image: %v", err)
+	}
+	if size, err := mirror.Size(); err != nil || mirror.size != size {
+		t.Errorf("Expected a running total of %d, got %d (%v)", size, mirror.size, err)
+	}
+}
+
+func TestMirrorDownload(t *testing.T) {
+	data := encodePNG(t, 5, 7)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/comic.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	mirror := NewMirror(t.TempDir(), 0)
+	info, err := mirror.Download(server.URL + "/comic.png")
+	if err != nil {
+		t.Fatalf("Failed to download image: %v", err)
+	}
+	if info.Width != 5 || info.Height != 7 || !mirror.Has(info.Hash) {
+		t.Errorf("Unexpected downloaded image: %+v", info)
+	}
+	if _, err := mirror.Download(server.URL + "/missing.png"); err == nil {
+		t.Errorf("Expected an error for a missing image")
+	}
+}
@@ -0,0 +1,136 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// DHash computes the difference hash of img: the image is reduced to 9x8
+// gray pixels and every bit tells whether a pixel is brighter than its right
+// neighbour. Similar looking images have hashes that differ in few bits,
+// whatever their size and format.
+func DHash(img image.Image) uint64 {
+	small := resizeTo(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// luminance returns the brightness of a pixel, counting transparent pixels
+// as white, which is what the background of a comic is.
+func luminance(img *image.RGBA, x, y int) int {
+	pixel := img.Pix[y*img.Stride+x*4:]
+	white := 255 - int(pixel[3])
+	r, g, b := int(pixel[0])+white, int(pixel[1])+white, int(pixel[2])+white
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// Distance is the Hamming distance of two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash and ParseHash convert a hash to and from the fixed width hex form it is stored in.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// Match is a comic found by a similarity search.
+type Match struct {
+	Num      int
+	Distance int
+}
+
+// BKTree indexes hashes by Hamming distance, so that the hashes close to a
+// given one are found without comparing it to all of them. Every child of a
+// node is keyed by its distance to the node; by the triangle inequality only
+// children whose key is within the search radius of the distance to the node
+// can hold matches.
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     uint64
+	nums     []int
+	children map[int]*bkNode
+}
+
+// Add adds the hash of a comic. Comics with the same hash share a node.
+func (t *BKTree) Add(hash uint64, num int) {
+	t.size++
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, nums: []int{num}}
+		return
+	}
+	node := t.root
+	for {
+		distance := Distance(hash, node.hash)
+		if distance == 0 {
+			node.nums = append(node.nums, num)
+			return
+		}
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[distance] = &bkNode{hash: hash, nums: []int{num}}
+			return
+		}
+		node = child
+	}
+}
+
+// Len returns the number of comics in the tree.
+func (t *BKTree) Len() int {
+	return t.size
+}
+
+// Search returns the comics whose hash is at most maxDistance from hash,
+// nearest first and by number among equally near ones.
+func (t *BKTree) Search(hash uint64, maxDistance int) []Match {
+	var matches []Match
+	if t.root != nil {
+		stack := []*bkNode{t.root}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			distance := Distance(hash, node.hash)
+			if distance <= maxDistance {
+				for _, num := range node.nums {
+					matches = append(matches, Match{Num: num, Distance: distance})
+				}
+			}
+			for key, child := range node.children {
+				if key >= distance-maxDistance && key <= distance+maxDistance {
+					stack = append(stack, child)
+				}
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Num < matches[j].Num
+	})
+	return matches
+}
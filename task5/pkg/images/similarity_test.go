@@ -0,0 +1,86 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// drawComic draws a panel layout: a white image with dark vertical bars.
+func drawComic(width, height int, bars []float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			level := uint8(255)
+			for _, bar := range bars {
+				if pos := float64(x) / float64(width); pos >= bar && pos < bar+0.05 {
+					level = uint8(40 * y / height)
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: level})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	original := DHash(drawComic(600, 200, []float64{0.3, 0.6}))
+	scaled := DHash(drawComic(300, 100, []float64{0.3, 0.6}))
+	different := DHash(drawComic(600, 200, []float64{0.1, 0.45, 0.8}))
+
+	if d := Distance(original, scaled); d > 4 {
+		t.Errorf("Expected a scaled copy to be close, got distance %d", d)
+	}
+	if d := Distance(original, different); d < 10 {
+		t.Errorf("Expected a different layout to be far, got distance %d", d)
+	}
+
+	parsed, err := ParseHash(FormatHash(original))
+	if err != nil || parsed != original {
+		t.Errorf("Expected %x to round trip, got %x, %v", original, parsed, err)
+	}
+}
+
+func TestBKTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := make([]uint64, 500)
+	tree := &BKTree{}
+	for num := range hashes {
+		hashes[num] = rng.Uint64()
+		if num%50 == 0 && num > 0 {
+			// Some near duplicates and exact duplicates of earlier comics.
+			hashes[num] = hashes[num-1] ^ 1<<uint(num%64)
+		}
+		if num%70 == 0 && num > 0 {
+			hashes[num] = hashes[num-1]
+		}
+		tree.Add(hashes[num], num)
+	}
+	if tree.Len() != len(hashes) {
+		t.Fatalf("Expected %d comics, got %d", len(hashes), tree.Len())
+	}
+
+	for _, maxDistance := range []int{0, 1, 20, 26} {
+		for _, query := range []int{0, 49, 69, 250} {
+			var want []Match
+			for num, hash := range hashes {
+				if d := Distance(hashes[query], hash); d <= maxDistance {
+					want = append(want, Match{Num: num, Distance: d})
+				}
+			}
+			sort.Slice(want, func(i, j int) bool {
+				if want[i].Distance != want[j].Distance {
+					return want[i].Distance < want[j].Distance
+				}
+				return want[i].Num < want[j].Num
+			})
+
+			if got := tree.Search(hashes[query], maxDistance); !reflect.DeepEqual(got, want) {
+				t.Errorf("Search(%d, %d) = %v, want %v", query, maxDistance, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Thumbnail opens a copy of the image with the given hash scaled down to
+// width, generating and storing it on first use. Images narrower than width
+// are not scaled up. Thumbnails live next to their image as <hash>-<width>
+// and are evicted like images. The returned error satisfies os.IsNotExist
+// if the image itself is not stored.
+func (m *Mirror) Thumbnail(hash string, width int) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, os.ErrNotExist
+	}
+	if width <= 0 {
+		return nil, fmt.Errorf("invalid thumbnail width %d", width)
+	}
+
+	path := m.thumbnailPath(hash, width)
+	if file, err := os.Open(path); err == nil {
+		touch(path)
+		return file, nil
+	}
+
+	source, err := m.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(source)
+	source.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image %s: %v", hash, err)
+	}
+
+	var buf bytes.Buffer
+	thumbnail := Resize(img, width)
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumbnail)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding thumbnail: %v", err)
+	}
+
+	m.mu.Lock()
+	tempFile := path + ".tmp"
+	err = os.WriteFile(tempFile, buf.Bytes(), 0644)
+	if err == nil {
+		err = os.Rename(tempFile, path)
+	}
+	if err == nil {
+		err = m.evict(path, int64(buf.Len()))
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error storing thumbnail: %v", err)
+	}
+	return os.Open(path)
+}
+
+// Resize scales img down to width, keeping its aspect ratio. Every pixel of
+// the result is the average of the source pixels it covers. Images that are
+// not wider than width are returned as they are.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if srcWidth <= width || srcHeight == 0 {
+		return img
+	}
+	height := (srcHeight*width + srcWidth/2) / srcWidth
+	if height < 1 {
+		height = 1
+	}
+	return resizeTo(img, width, height)
+}
+
+// resizeTo scales img to exactly width x height by averaging the source
+// pixels every result pixel covers.
+func resizeTo(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	// Averaging premultiplied colors keeps transparent pixels from darkening edges.
+	src := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, srcHeight)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, srcWidth)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			count := (x1 - x0) * (y1 - y0)
+			pixel := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				pixel[i] = uint8((sum[i] + count/2) / count)
+			}
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels [start, end) covered by pixel i of n when
+// scaling from size source pixels. Every pixel covers at least one source pixel,
+// also when scaling up.
+func span(i, n, size int) (int, int) {
+	start, end := i*size/n, (i+1)*size/n
+	if end == start {
+		end = start + 1
+	}
+	return start, end
+}
+
+func (m *Mirror) thumbnailPath(hash string, width int) string {
+	return m.path(hash) + "-" + strconv.Itoa(width)
+}
+
+// validStoredName reports whether name is the name of an image or a thumbnail.
+func validStoredName(name string) bool {
+	hash, width, ok := strings.Cut(name, "-")
+	if !ok {
+		return validHash(name)
+	}
+	n, err := strconv.Atoi(width)
+	return validHash(hash) && err == nil && n > 0
+}
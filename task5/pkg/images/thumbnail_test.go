@@ -0,0 +1,74 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	// Alternating black and white columns average to gray.
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x += 2 {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		width         int
+		wantW, wantH  int
+		wantGrayLevel uint8
+	}{
+		{name: "half", width: 20, wantW: 20, wantH: 10, wantGrayLevel: 128},
+		{name: "quarter", width: 10, wantW: 10, wantH: 5, wantGrayLevel: 128},
+		{name: "wider than the image", width: 80, wantW: 40, wantH: 20},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resized := Resize(img, tc.width)
+			bounds := resized.Bounds()
+			if bounds.Dx() != tc.wantW || bounds.Dy() != tc.wantH {
+				t.Fatalf("Expected %dx%d, got %dx%d", tc.wantW, tc.wantH, bounds.Dx(), bounds.Dy())
+			}
+			if tc.wantGrayLevel == 0 {
+				return
+			}
+			r, g, b, a := resized.At(bounds.Dx()/2, bounds.Dy()/2).RGBA()
+			if uint8(r>>8) != tc.wantGrayLevel || uint8(g>>8) != tc.wantGrayLevel || uint8(b>>8) != tc.wantGrayLevel || a != 0xffff {
+				t.Errorf("Expected gray %d, got %d %d %d %d", tc.wantGrayLevel, r>>8, g>>8, b>>8, a>>8)
+			}
+		})
+	}
+}
+
+func TestMirrorThumbnail(t *testing.T) {
+	mirror := NewMirror(t.TempDir(), 0)
+	info, err := mirror.Store(encodePNG(t, 60, 30))
+	if err != nil {
+		t.Fatalf("Failed to store image: %v", err)
+	}
+
+	file, err := mirror.Thumbnail(info.Hash, 20)
+	if err != nil {
+		t.Fatalf("Failed to make thumbnail: %v", err)
+	}
+	thumbnail, format, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil || format != "png" || thumbnail.Width != 20 || thumbnail.Height != 10 {
+		t.Errorf("Unexpected thumbnail: %+v %s %v", thumbnail, format, err)
+	}
+	if _, err := os.Stat(mirror.thumbnailPath(info.Hash, 20)); err != nil {
+		t.Errorf("Expected the thumbnail to be cached: %v", err)
+	}
+	if size, _ := mirror.Size(); size <= info.Size {
+		t.Errorf("Expected the thumbnail to count towards the mirror size, got %d", size)
+	}
+
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	if _, err := mirror.Thumbnail(missing, 20); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing image to be not found, got %v", err)
+	}
+}
@@ -1,8 +1,36 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
 type Comic struct {
-	Num        int    `json:"num"`
-	Transcript string `json:"transcript"`
-	Alt        string `json:"alt"`
-	Img        string `json:"img"`
+	Num        int             `json:"num"`
+	Title      string          `json:"title"`
+	SafeTitle  string          `json:"safe_title"`
+	Year       string          `json:"year"`
+	Month      string          `json:"month"`
+	Day        string          `json:"day"`
+	Link       string          `json:"link"`
+	News       string          `json:"news"`
+	Transcript string          `json:"transcript"`
+	Alt        string          `json:"alt"`
+	Img        string          `json:"img"`
+	ExtraParts json.RawMessage `json:"extra_parts,omitempty"`
+
+	// Raw is the upstream JSON document the comic was decoded from.
+	Raw json.RawMessage `json:"-"`
+}
+
+// Date returns the publication date as YYYY-MM-DD, or an empty string if it is unknown.
+func (c Comic) Date() string {
+	year, errYear := strconv.Atoi(c.Year)
+	month, errMonth := strconv.Atoi(c.Month)
+	day, errDay := strconv.Atoi(c.Day)
+	if errYear != nil || errMonth != nil || errDay != nil {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
 }
@@ -0,0 +1,289 @@
+package search
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// KeywordsField names the matches of unscoped words in the keyword index,
+// which is only searched for comics whose alt text and transcript were not
+// indexed on their own.
+const KeywordsField = "keywords"
+
+// defaultFieldBoosts weigh the matches of unscoped query words per field, so
+// that a word in the title counts for more than one in the alt text. Words
+// scoped to a field are not boosted.
+var defaultFieldBoosts = map[string]float64{"title": 2, "alt": 0.5, "transcript": 1}
+
+// CheckFieldBoosts reports whether boosts can be passed to NewCorpus: every
+// one must name a field and not be negative.
+func CheckFieldBoosts(boosts map[string]float64) error {
+	for field, boost := range boosts {
+		if _, ok := defaultFieldBoosts[field]; !ok {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if boost < 0 {
+			return fmt.Errorf("negative boost %v of field %q", boost, field)
+		}
+	}
+	return nil
+}
+
+// Corpus is what queries are evaluated against: the keyword index with the
+// indexes of its fields, the comics and the dictionaries of the indexes for
+// fuzzy matching. Dictionaries are built on first use.
+type Corpus struct {
+	index  words.PostingsSource
+	comics map[int]*database.ComicKeywords
+	// boosts override the default boosts of the fields they name.
+	boosts map[string]float64
+
+	mu           sync.Mutex
+	dictionaries map[string]*words.Dictionary
+}
+
+// NewCorpus returns a corpus scoring fields with the given boosts, which
+// must have passed CheckFieldBoosts. Fields left out keep their default boost.
+func NewCorpus(index words.PostingsSource, comics map[int]*database.ComicKeywords, boosts map[string]float64) *Corpus {
+	return &Corpus{
+		index:        index,
+		comics:       comics,
+		boosts:       boosts,
+		dictionaries: make(map[string]*words.Dictionary),
+	}
+}
+
+// IndexFields builds the dictionaries of all fields up front.
+func (c *Corpus) IndexFields() {
+	c.dictionary("")
+	for _, field := range Fields {
+		c.dictionary(field)
+	}
+}
+
+// dictionary returns the dictionary of the index of a field.
+func (c *Corpus) dictionary(field string) *words.Dictionary {
+	index := c.fieldIndex(field)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dict, ok := c.dictionaries[field]
+	if !ok {
+		dict = words.NewDictionary(index.Vocabulary())
+		c.dictionaries[field] = dict
+	}
+	return dict
+}
+
+// fieldIndex returns the index of a field, or the keyword index for "".
+func (c *Corpus) fieldIndex(field string) words.PostingsSource {
+	if field == "" {
+		return c.index
+	}
+	return c.index.Field(field)
+}
+
+// source is an index a query word is looked up in, the field its matches
+// are reported as and the boost of their score.
+type source struct {
+	field string
+	name  string
+	boost float64
+	// legacy restricts the matches to comics without indexed fields, see hasFields.
+	legacy bool
+}
+
+// sources returns where the words of a field are looked up. Unscoped words
+// are looked up in every field, and in the keyword index for the comics
+// whose fields are not indexed.
+func (c *Corpus) sources(field string) []source {
+	if field != "" {
+		return []source{{field: field, name: field, boost: 1}}
+	}
+	sources := make([]source, 0, len(Fields)+1)
+	for _, field := range Fields {
+		sources = append(sources, source{field: field, name: field, boost: c.boost(field)})
+	}
+	return append(sources, source{name: KeywordsField, boost: 1, legacy: true})
+}
+
+// boost returns the boost of an unscoped match in a field.
+func (c *Corpus) boost(field string) float64 {
+	if boost, ok := c.boosts[field]; ok {
+		return boost
+	}
+	return defaultFieldBoosts[field]
+}
+
+// addScored adds the results scored in a source with its boost applied.
+func (c *Corpus) addScored(found matches, s source, scored []words.Result) {
+	for _, result := range scored {
+		if s.legacy {
+			if c.comics[result.ID] == nil || c.hasFields(result.ID) {
+				continue
+			}
+		}
+		result.Score *= s.boost
+		result.Fields = map[string]float64{s.name: result.Score}
+		found.add(result)
+	}
+}
+
+// hasFields reports whether the alt text or the transcript of a comic is
+// indexed on its own. They are not for comics stored without their upstream
+// JSON, and for none in indexes of older formats.
+func (c *Corpus) hasFields(id int) bool {
+	return c.fieldIndex("alt").DocLength(id) > 0 || c.fieldIndex("transcript").DocLength(id) > 0
+}
+
+// Search returns the comics matching the query, best first, see words.SortResults.
+func (q *Query) Search(c *Corpus) []words.Result {
+	matches := q.eval(c, q.root)
+	results := make([]words.Result, 0, len(matches))
+	for _, result := range matches {
+		results = append(results, *result)
+	}
+	words.SortResults(results)
+	return results
+}
+
+type matches map[int]*words.Result
+
+func (m matches) add(result words.Result) {
+	if existing, ok := m[result.ID]; ok {
+		merge(existing, result)
+		return
+	}
+	result.Fields = scaleFields(result.Fields, 1)
+	m[result.ID] = &result
+}
+
+// merge adds the score, phrases and field scores of other to result.
+func merge(result *words.Result, other words.Result) {
+	result.Score += other.Score
+	result.Phrases += other.Phrases
+	if len(other.Fields) > 0 && result.Fields == nil {
+		result.Fields = make(map[string]float64, len(other.Fields))
+	}
+	for field, score := range other.Fields {
+		result.Fields[field] += score
+	}
+}
+
+// scaleFields returns a copy of the field scores multiplied by factor.
+func scaleFields(fields map[string]float64, factor float64) map[string]float64 {
+	if fields == nil {
+		return nil
+	}
+	scaled := make(map[string]float64, len(fields))
+	for field, score := range fields {
+		scaled[field] = score * factor
+	}
+	return scaled
+}
+
+func (q *Query) eval(c *Corpus, n node) matches {
+	found := make(matches)
+	switch n := n.(type) {
+	case termNode:
+		sources := c.sources(n.field)
+		for _, s := range sources {
+			index := c.fieldIndex(s.field)
+			c.addScored(found, s, words.ScorePostings(index, index.Postings(n.term)))
+		}
+		// A misspelled word is one no field knows.
+		if len(found) == 0 && q.Fuzzy.MaxEdits(n.term) > 0 {
+			for _, s := range sources {
+				index := c.fieldIndex(s.field)
+				c.addScored(found, s, words.FuzzyResults(index, c.dictionary(s.field), n.term, q.Fuzzy))
+			}
+		}
+	case phraseNode:
+		for _, s := range c.sources(n.field) {
+			index := c.fieldIndex(s.field)
+			c.addScored(found, s, words.ScorePostings(index, words.PhrasePostings(index, n.phrase)))
+		}
+		for _, result := range found {
+			result.Phrases = 1
+		}
+	case synonymNode:
+		// Synonyms come from rules, so they are not fuzzy matched.
+		exact := *q
+		exact.Fuzzy = words.FuzzyOff
+		for _, result := range exact.eval(c, n.clause) {
+			result.Score *= words.SynonymWeight
+			result.Fields = scaleFields(result.Fields, words.SynonymWeight)
+			result.Phrases = 0
+			found.add(*result)
+		}
+	case numNode:
+		if _, ok := c.comics[n.num]; ok {
+			found.add(words.Result{ID: n.num})
+		}
+	case notNode:
+		found = q.exclude(c, q.all(c), []node{n})
+	case anyNode:
+		var positive []node
+		for _, clause := range n.clauses {
+			if _, ok := clause.(notNode); !ok {
+				positive = append(positive, clause)
+			}
+		}
+		if len(positive) == 0 {
+			return q.exclude(c, q.all(c), n.clauses)
+		}
+		for _, clause := range positive {
+			for _, result := range q.eval(c, clause) {
+				found.add(*result)
+			}
+		}
+		found = q.exclude(c, found, n.clauses)
+	case andNode:
+		found = nil
+		for _, clause := range n.clauses {
+			if _, ok := clause.(notNode); ok {
+				continue
+			}
+			clauseMatches := q.eval(c, clause)
+			if found == nil {
+				found = clauseMatches
+				continue
+			}
+			for id, result := range found {
+				if other, ok := clauseMatches[id]; ok {
+					merge(result, *other)
+				} else {
+					delete(found, id)
+				}
+			}
+		}
+		if found == nil {
+			found = q.all(c)
+		}
+		found = q.exclude(c, found, n.clauses)
+	}
+	return found
+}
+
+// exclude removes the comics matching the notNode clauses from found.
+func (q *Query) exclude(c *Corpus, found matches, clauses []node) matches {
+	for _, clause := range clauses {
+		if not, ok := clause.(notNode); ok {
+			for id := range q.eval(c, not.clause) {
+				delete(found, id)
+			}
+		}
+	}
+	return found
+}
+
+// all matches every comic with no score.
+func (q *Query) all(c *Corpus) matches {
+	found := make(matches, len(c.comics))
+	for num := range c.comics {
+		found.add(words.Result{ID: num})
+	}
+	return found
+}
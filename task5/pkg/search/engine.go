@@ -0,0 +1,108 @@
+package search
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// Snapshot is an immutable view of the comics and their index.
+// It must not be modified once published by an Engine.
+type Snapshot struct {
+	Index  words.Index
+	Comics map[int]*database.ComicKeywords
+	// Corpus evaluates queries over Index and Comics.
+	Corpus *Corpus
+	// Images indexes the perceptual hashes of the comic images.
+	Images *images.BKTree
+	// Suggester completes words from Index.
+	Suggester *words.Suggester
+	// Corrector corrects misspelled words to words of Index.
+	Corrector *words.Corrector
+}
+
+// Hit is a comic found by a search, its score and the part of the score
+// each field matched contributed.
+type Hit struct {
+	Comic  *database.ComicKeywords
+	Score  float64
+	Fields map[string]float64
+}
+
+// Engine serves searches from an in-memory snapshot that can be replaced
+// atomically while searches are running.
+type Engine struct {
+	snapshot atomic.Pointer[Snapshot]
+	// boosts are the field boosts of every snapshot, see NewCorpus.
+	boosts map[string]float64
+}
+
+func NewEngine(store database.Store, boosts map[string]float64) (*Engine, error) {
+	engine := &Engine{boosts: boosts}
+	if err := engine.Reload(store); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// Reload builds a new snapshot from the store and swaps it in.
+func (e *Engine) Reload(store database.Store) error {
+	comics, err := database.LoadAllComics(store)
+	if err != nil {
+		return fmt.Errorf("failed to load comics: %v", err)
+	}
+
+	index := database.MakeIndex(comics)
+	corpus := NewCorpus(index, comics, e.boosts)
+	corpus.IndexFields()
+	e.snapshot.Store(&Snapshot{
+		Index:     index,
+		Comics:    comics,
+		Corpus:    corpus,
+		Images:    MakeImageTree(comics),
+		Suggester: words.NewSuggester(index),
+		Corrector: words.NewCorrector(index),
+	})
+	return nil
+}
+
+func (e *Engine) Snapshot() *Snapshot {
+	return e.snapshot.Load()
+}
+
+// Search returns the comics matching the query, best matches first. See
+// Query for the syntax; a syntax error is returned as a *ParseError.
+func (e *Engine) Search(query string, fuzzy words.Fuzziness) ([]Hit, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q.Fuzzy = fuzzy
+	snapshot := e.Snapshot()
+	return resultHits(snapshot.Comics, q.Search(snapshot.Corpus)), nil
+}
+
+// DidYouMean returns a spelling correction of a query that found nothing,
+// or "" if there is none, see DidYouMean.
+func (e *Engine) DidYouMean(query string, fuzzy words.Fuzziness) string {
+	snapshot := e.Snapshot()
+	return DidYouMean(snapshot.Corrector, snapshot.Corpus, query, fuzzy)
+}
+
+// Suggest completes the last word of a partly typed query, see words.Suggester.
+func (e *Engine) Suggest(query string, limit int) []words.Suggestion {
+	return e.Snapshot().Suggester.Suggest(query, limit)
+}
+
+func resultHits(comics map[int]*database.ComicKeywords, results []words.Result) []Hit {
+	hits := make([]Hit, 0, len(results))
+	for _, result := range results {
+		if comic, ok := comics[result.ID]; ok {
+			hits = append(hits, Hit{Comic: comic, Score: result.Score, Fields: result.Fields})
+		}
+	}
+	return hits
+}
@@ -0,0 +1,513 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+// Fields are the fields a query term can be scoped to with a field: prefix,
+// besides num:, which matches a comic by its number.
+var Fields = database.Fields
+
+// ParseError is a syntax error in a query. Pos is the position of the
+// offending character, counting characters from 1.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at character %d", e.Msg, e.Pos)
+}
+
+// Query is a parsed search query. The syntax is:
+//
+//	word          matches comics containing the word
+//	"a phrase"    matches comics containing the words next to each other
+//	"a phrase"~N  matches comics containing the words within N keywords
+//	a b, a OR b   matches comics containing either
+//	a AND b       matches comics containing both
+//	-a, NOT a     excludes comics containing a from the group it is in
+//	( ... )       groups
+//	field:term    scopes a word, phrase or group to the title, alt or
+//	              transcript field; num:N matches comic N
+//
+// Operators are upper case; in lower case they are ordinary words. Words
+// next to each other need not all match, so a plain query of words ranks the
+// comics containing any of them by BM25, see words.ScorePostings. Within such a
+// group a phrase also matches its words on their own, ranked below the exact
+// phrase; combined with AND or NOT it has to match exactly. Words, runs of
+// words and phrases also match their synonyms, ranked below themselves, see
+// words.LoadSynonyms.
+type Query struct {
+	root node
+	// Fuzzy is how terms that are not in the index are matched to similar
+	// ones. It is automatic unless changed.
+	Fuzzy words.Fuzziness
+}
+
+type node interface{}
+
+type termNode struct {
+	field string
+	term  string
+}
+
+type phraseNode struct {
+	field  string
+	phrase words.Phrase
+}
+
+type numNode struct {
+	num int
+}
+
+// anyNode matches comics matching any of its clauses, andNode comics
+// matching all of them. Comics matching a notNode clause are excluded from both.
+type anyNode struct {
+	clauses []node
+}
+
+type andNode struct {
+	clauses []node
+}
+
+type notNode struct {
+	clause node
+}
+
+// synonymNode matches what its clause matches, but with a lower score.
+type synonymNode struct {
+	clause node
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenField
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// within is the proximity of a phrase.
+	within int
+	pos    int
+}
+
+// ParseQuery parses a query. The returned error is a *ParseError.
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseAny("")
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", describe(t))}
+	}
+	return &Query{root: expandSynonyms(root), Fuzzy: words.FuzzyAuto}, nil
+}
+
+// expandSynonyms adds the synonyms of words and phrases to the groups they
+// are in. Words and phrases outside of a group get a group of their own.
+// Synonyms of consecutive words of a group are found as well.
+func expandSynonyms(n node) node {
+	switch n := n.(type) {
+	case termNode, phraseNode:
+		if expanded := expandSynonyms(anyNode{clauses: []node{n}}).(anyNode); len(expanded.clauses) > 1 {
+			return expanded
+		}
+		return n
+	case andNode:
+		clauses := make([]node, len(n.clauses))
+		for i, clause := range n.clauses {
+			clauses[i] = expandSynonyms(clause)
+		}
+		return andNode{clauses: clauses}
+	case notNode:
+		return notNode{clause: expandSynonyms(n.clause)}
+	case anyNode:
+		var clauses, synonyms []node
+		var run []termNode
+		flush := func() {
+			synonyms = append(synonyms, termSynonyms(run)...)
+			run = nil
+		}
+		for _, clause := range n.clauses {
+			switch clause := clause.(type) {
+			case termNode:
+				if len(run) > 0 && run[0].field != clause.field {
+					flush()
+				}
+				run = append(run, clause)
+				clauses = append(clauses, clause)
+			case phraseNode:
+				flush()
+				synonyms = append(synonyms, phraseSynonyms(clause)...)
+				clauses = append(clauses, clause)
+			default:
+				flush()
+				clauses = append(clauses, expandSynonyms(clause))
+			}
+		}
+		flush()
+
+		seen := make(map[string]bool)
+		for _, clause := range clauses {
+			seen[fmt.Sprintf("%T%v", clause, clause)] = true
+		}
+		for _, synonym := range synonyms {
+			clause := synonym.(synonymNode).clause
+			if key := fmt.Sprintf("%T%v", clause, clause); !seen[key] {
+				seen[key] = true
+				clauses = append(clauses, synonym)
+			}
+		}
+		return anyNode{clauses: clauses}
+	}
+	return n
+}
+
+// termSynonyms returns the synonyms of consecutive words of a field.
+func termSynonyms(run []termNode) []node {
+	if len(run) == 0 {
+		return nil
+	}
+	terms := make([]string, len(run))
+	for i, term := range run {
+		terms[i] = term.term
+	}
+	var synonyms []node
+	for _, synonym := range words.Synonyms(terms) {
+		synonyms = append(synonyms, synonymNode{clause: termsClause(run[0].field, synonym.Terms, 0)})
+	}
+	return synonyms
+}
+
+// phraseSynonyms returns the phrase with each of the synonyms of its words
+// in place of them.
+func phraseSynonyms(n phraseNode) []node {
+	var synonyms []node
+	for _, synonym := range words.Synonyms(n.phrase.Terms) {
+		clause := termsClause(n.field, synonym.Substitute(n.phrase.Terms), n.phrase.Within)
+		synonyms = append(synonyms, synonymNode{clause: clause})
+	}
+	return synonyms
+}
+
+// termsClause matches a single term or a phrase of several.
+func termsClause(field string, terms []string, within int) node {
+	if len(terms) == 1 {
+		return termNode{field: field, term: terms[0]}
+	}
+	return phraseNode{field: field, phrase: words.Phrase{Terms: terms, Within: within}}
+}
+
+func lex(query string) ([]token, error) {
+	runes := []rune(query)
+	var tokens []token
+	endsWord := func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, pos: pos})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &ParseError{Pos: pos, Msg: "unterminated phrase"}
+			}
+			t := token{kind: tokenPhrase, text: string(runes[i+1 : end]), pos: pos}
+			i = end + 1
+			if i < len(runes) && runes[i] == '~' {
+				start := i + 1
+				for i = start; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+				}
+				within, err := strconv.Atoi(string(runes[start:i]))
+				if err != nil {
+					return nil, &ParseError{Pos: start + 1, Msg: "expected a number after ~"}
+				}
+				t.within = within
+			}
+			tokens = append(tokens, t)
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+			tokens = append(tokens, token{kind: tokenNot, text: "-", pos: pos})
+			i++
+		default:
+			end := i
+			for end < len(runes) && !endsWord(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			if name, _, ok := strings.Cut(word, ":"); ok && isField(strings.ToLower(name)) {
+				tokens = append(tokens, token{kind: tokenField, text: strings.ToLower(name), pos: pos})
+				i += len([]rune(name)) + 1
+				if i == len(runes) || unicode.IsSpace(runes[i]) || runes[i] == ')' {
+					return nil, &ParseError{Pos: i + 1, Msg: fmt.Sprintf("expected a value after %s:", name)}
+				}
+				continue
+			}
+			kind := tokenWord
+			switch word {
+			case "AND":
+				kind = tokenAnd
+			case "OR":
+				kind = tokenOr
+			case "NOT":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind: kind, text: word, pos: pos})
+			i = end
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+func isField(name string) bool {
+	if name == "num" {
+		return true
+	}
+	for _, field := range Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenOpen:
+		return "'('"
+	case tokenClose:
+		return "')'"
+	case tokenField:
+		return t.text + ":"
+	default:
+		return t.text
+	}
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	p.next++
+	return t
+}
+
+// startsClause reports whether the next token can start a clause.
+func (p *parser) startsClause() bool {
+	switch p.peek().kind {
+	case tokenWord, tokenPhrase, tokenField, tokenNot, tokenOpen:
+		return true
+	}
+	return false
+}
+
+// expectClause fails unless the next token can start a clause following op.
+func (p *parser) expectClause(op token) error {
+	if p.startsClause() {
+		return nil
+	}
+	return &ParseError{Pos: p.peek().pos, Msg: fmt.Sprintf("expected a term after %s, got %s", op.text, describe(p.peek()))}
+}
+
+// parseAny parses clauses separated by spaces or OR up to the end of the
+// query or of the group.
+func (p *parser) parseAny(field string) (node, error) {
+	var clauses []node
+	for {
+		t := p.peek()
+		if t.kind == tokenEOF || t.kind == tokenClose {
+			break
+		}
+		if t.kind == tokenOr {
+			if len(clauses) == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: "unexpected OR"}
+			}
+			p.advance()
+			if err := p.expectClause(t); err != nil {
+				return nil, err
+			}
+		}
+		clause, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	return makeAny(clauses), nil
+}
+
+// makeAny adds the words of phrases as clauses of their own and drops
+// empty and repeated clauses.
+func makeAny(clauses []node) node {
+	var kept []node
+	seen := make(map[string]bool)
+	add := func(clause node) {
+		if clause == nil {
+			return
+		}
+		key := fmt.Sprintf("%T%v", clause, clause)
+		if !seen[key] {
+			seen[key] = true
+			kept = append(kept, clause)
+		}
+	}
+	for _, clause := range clauses {
+		add(clause)
+		if phrase, ok := clause.(phraseNode); ok {
+			for _, term := range phrase.phrase.Terms {
+				add(termNode{field: phrase.field, term: term})
+			}
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return nil
+	case 1:
+		if _, ok := kept[0].(notNode); !ok {
+			return kept[0]
+		}
+	}
+	return anyNode{clauses: kept}
+}
+
+func (p *parser) parseAnd(field string) (node, error) {
+	clause, err := p.parseUnary(field)
+	if err != nil {
+		return nil, err
+	}
+	clauses := []node{clause}
+	for p.peek().kind == tokenAnd {
+		op := p.advance()
+		if err := p.expectClause(op); err != nil {
+			return nil, err
+		}
+		clause, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+
+	var kept []node
+	for _, clause := range clauses {
+		if clause != nil {
+			kept = append(kept, clause)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	return andNode{clauses: kept}, nil
+}
+
+func (p *parser) parseUnary(field string) (node, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary(field)
+	}
+	op := p.advance()
+	if err := p.expectClause(op); err != nil {
+		return nil, err
+	}
+	clause, err := p.parseUnary(field)
+	if err != nil || clause == nil {
+		return nil, err
+	}
+	return notNode{clause: clause}, nil
+}
+
+func (p *parser) parsePrimary(field string) (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenOpen:
+		if p.peek().kind == tokenClose {
+			return nil, &ParseError{Pos: t.pos, Msg: "empty group"}
+		}
+		clause, err := p.parseAny(field)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenClose {
+			return nil, &ParseError{Pos: t.pos, Msg: "unclosed '('"}
+		}
+		p.advance()
+		return clause, nil
+
+	case tokenField:
+		if field != "" {
+			return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("field %s: inside field %s:", t.text, field)}
+		}
+		if t.text != "num" {
+			return p.parsePrimary(t.text)
+		}
+		value := p.advance()
+		num, err := strconv.Atoi(value.text)
+		if value.kind != tokenWord || err != nil {
+			return nil, &ParseError{Pos: value.pos, Msg: "expected a comic number after num:"}
+		}
+		return numNode{num: num}, nil
+
+	case tokenWord:
+		terms := words.NormalizeInput(t.text)
+		clauses := make([]node, len(terms))
+		for i, term := range terms {
+			clauses[i] = termNode{field: field, term: term}
+		}
+		return makeAny(clauses), nil
+
+	case tokenPhrase:
+		terms := words.NormalizeInput(t.text)
+		switch len(terms) {
+		case 0:
+			return nil, nil
+		case 1:
+			return termNode{field: field, term: terms[0]}, nil
+		}
+		return phraseNode{field: field, phrase: words.Phrase{Terms: terms, Within: t.within}}, nil
+	}
+	return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", describe(t))}
+}
@@ -0,0 +1,329 @@
+package search
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+func testCorpus(t *testing.T) (*Corpus, words.Index) {
+	upstream := []models.Comic{
+		{Num: 1, Title: "Barrel", Transcript: "boy floating in a barrel", Alt: "where is the island"},
+		{Num: 2, Title: "Island", Transcript: "boy reaches the island", Alt: "barrel left behind"},
+		{Num: 3, Title: "Password Strength", Transcript: "correct horse battery staple", Alt: "horse memory"},
+		{Num: 4, Title: "Raft", Transcript: "staple a battery to the horse", Alt: "ocean raft"},
+	}
+	comics := make(map[int]*database.ComicKeywords)
+	for _, comic := range upstream {
+		raw, err := json.Marshal(comic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keywords := words.NormalizeInput(comic.Transcript + " " + comic.Alt)
+		comics[comic.Num] = &database.ComicKeywords{Num: comic.Num, Title: comic.Title, Raw: raw, Keywords: keywords}
+	}
+	index := database.MakeIndex(comics)
+	return NewCorpus(index, comics, nil), index
+}
+
+func TestQuerySearch(t *testing.T) {
+	corpus, index := testCorpus(t)
+
+	// The fields are scored from the postings stored in the index file, so
+	// the upstream JSON is not needed at query time.
+	indexFile := filepath.Join(t.TempDir(), "index.bin")
+	if err := os.WriteFile(indexFile, words.EncodeBinaryIndex(index), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := words.OpenMappedIndex(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	stripped := make(map[int]*database.ComicKeywords, len(corpus.comics))
+	for num, comic := range corpus.comics {
+		stripped[num] = &database.ComicKeywords{Num: num, Keywords: comic.Keywords}
+	}
+	corpora := map[string]*Corpus{"built": corpus, "mapped": NewCorpus(mapped, stripped, nil)}
+
+	testCases := []struct {
+		query    string
+		expected []int
+	}{
+		{query: "island AND boy", expected: []int{2, 1}},
+		{query: "barrel AND NOT island", expected: []int{}},
+		{query: "barrel -reaches", expected: []int{1}},
+		{query: "(barrel OR raft) AND ocean", expected: []int{4}},
+		{query: `"horse battery" AND -memory`, expected: []int{}},
+		{query: `"battery horse"~3 AND staple`, expected: []int{3, 4}},
+		{query: "title:island", expected: []int{2}},
+		{query: "alt:barrel", expected: []int{2}},
+		{query: "transcript:(barrel OR ocean)", expected: []int{1}},
+		{query: `title:"password strength"`, expected: []int{3}},
+		{query: "num:4 OR num:99", expected: []int{4}},
+		{query: "NOT horse", expected: []int{1, 2}},
+		{query: "-(horse OR boy)", expected: []int{}},
+		{query: "Note: raft", expected: []int{4}},
+	}
+	for _, tc := range testCases {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tc.query, err)
+			continue
+		}
+		for name, corpus := range corpora {
+			ids := []int{}
+			for _, result := range q.Search(corpus) {
+				ids = append(ids, result.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("Query %q on the %s index: expected %v, got %v", tc.query, name, tc.expected, ids)
+			}
+		}
+	}
+
+	// Misspelled terms match the terms of their field unless fuzzy matching is off.
+	q, err := ParseQuery("title:pasword AND stapel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := q.Search(corpus); len(results) != 1 || results[0].ID != 3 {
+		t.Errorf("Expected comic 3 for a fuzzy query, got %v", results)
+	}
+	q.Fuzzy = words.FuzzyOff
+	if results := q.Search(corpus); len(results) != 0 {
+		t.Errorf("Expected no results with fuzzy matching off, got %v", results)
+	}
+}
+
+// keywordCorpus is a corpus of comics stored with keywords only.
+func keywordCorpus(documents map[int][]string) *Corpus {
+	comics := make(map[int]*database.ComicKeywords, len(documents))
+	for num, keywords := range documents {
+		comics[num] = &database.ComicKeywords{Num: num, Keywords: keywords}
+	}
+	return NewCorpus(database.MakeIndex(comics), comics, nil)
+}
+
+func TestPlainQueryRanking(t *testing.T) {
+	ranking := keywordCorpus(map[int][]string{
+		1: words.NormalizeInput("barrel barrel barrel"),
+		2: words.NormalizeInput("barrel island"),
+		3: words.NormalizeInput("island boy"),
+		4: words.NormalizeInput("barrel island boat raft ocean wave"),
+		5: words.NormalizeInput("barrel island"),
+	})
+	phrases := keywordCorpus(map[int][]string{
+		1: {"correct", "hors", "batteri", "stapl"},
+		2: {"stapl", "batteri", "hors", "correct", "stapl", "hors"},
+		3: {"hors", "batteri", "long", "wind", "stapl"},
+		4: {"hors"},
+	})
+
+	testCases := []struct {
+		name     string
+		corpus   *Corpus
+		query    string
+		expected []int
+		phrases  []int
+	}{
+		{name: "frequent term ranks higher", corpus: ranking, query: "barrel", expected: []int{1, 2, 5, 4}},
+		{name: "rare term outweighs common one", corpus: ranking, query: "barrel boy", expected: []int{3, 1, 2, 5, 4}},
+		{name: "repeated query term counts once", corpus: ranking, query: "island island boy", expected: []int{3, 2, 5, 4}},
+		{name: "no match", corpus: ranking, query: "volcano", expected: []int{}},
+		// Comic 1 has the phrase; the others only have some of the words.
+		{name: "phrase", corpus: phrases, query: `"correct horse battery staple"`, expected: []int{1, 2, 3, 4}, phrases: []int{1, 0, 0, 0}},
+		{name: "short phrase", corpus: phrases, query: `"horse battery"`, expected: []int{1, 3, 2, 4}, phrases: []int{1, 1, 0, 0}},
+		{name: "proximity", corpus: phrases, query: `"staple horse"~3`, expected: []int{2, 1, 3, 4}, phrases: []int{1, 1, 0, 0}},
+		{name: "two phrases", corpus: phrases, query: `"winding staple" "horse battery"`, expected: []int{3, 1, 2, 4}, phrases: []int{2, 1, 0, 0}},
+	}
+	for _, tc := range testCases {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tc.query, err)
+		}
+		results := q.Search(tc.corpus)
+		ids, phrases := []int{}, []int{}
+		for _, result := range results {
+			ids = append(ids, result.ID)
+			phrases = append(phrases, result.Phrases)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) || (tc.phrases != nil && !reflect.DeepEqual(phrases, tc.phrases)) {
+			t.Errorf("%s: expected %v with phrases %v, got %v", tc.name, tc.expected, tc.phrases, results)
+		}
+	}
+}
+
+func TestFieldBoosts(t *testing.T) {
+	corpus, _ := testCorpus(t)
+	// A comic stored without its upstream JSON is only in the keyword index.
+	corpus.comics[5] = &database.ComicKeywords{Num: 5, Keywords: words.NormalizeInput("barrel volcano")}
+	index := database.MakeIndex(corpus.comics)
+
+	search := func(query string, boosts map[string]float64) []words.Result {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", query, err)
+		}
+		return q.Search(NewCorpus(index, corpus.comics, boosts))
+	}
+	fields := func(result words.Result) []string {
+		var names []string
+		for field := range result.Fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	testCases := []struct {
+		query  string
+		boosts map[string]float64
+		ids    []int
+		fields [][]string
+	}{
+		{query: "barrel", ids: []int{1, 5, 2},
+			fields: [][]string{{"title", "transcript"}, {KeywordsField}, {"alt"}}},
+		{query: "alt:barrel", ids: []int{2}, fields: [][]string{{"alt"}}},
+		{query: `"staple a battery" AND horse`, ids: []int{4}, fields: [][]string{{"transcript"}}},
+		{query: "volcano", ids: []int{5}, fields: [][]string{{KeywordsField}}},
+		{query: "barrel", boosts: map[string]float64{"title": 0, "transcript": 0, "alt": 10},
+			ids: []int{2, 5, 1}, fields: [][]string{{"alt"}, {KeywordsField}, {"title", "transcript"}}},
+	}
+	for _, tc := range testCases {
+		results := search(tc.query, tc.boosts)
+
+		var ids []int
+		var gotFields [][]string
+		for _, result := range results {
+			ids = append(ids, result.ID)
+			gotFields = append(gotFields, fields(result))
+			var sum float64
+			for _, score := range result.Fields {
+				sum += score
+			}
+			if math.Abs(sum-result.Score) > 1e-9 {
+				t.Errorf("Query %q: field scores %v of comic %d do not add up to %v", tc.query, result.Fields, result.ID, result.Score)
+			}
+		}
+		if !reflect.DeepEqual(ids, tc.ids) || !reflect.DeepEqual(gotFields, tc.fields) {
+			t.Errorf("Query %q with boosts %v: expected %v %v, got %v %v", tc.query, tc.boosts, tc.ids, tc.fields, ids, gotFields)
+		}
+	}
+
+	if err := CheckFieldBoosts(map[string]float64{"title": 0, "alt": 10}); err != nil {
+		t.Errorf("Expected valid boosts, got %v", err)
+	}
+	if err := CheckFieldBoosts(map[string]float64{"body": 1}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if err := CheckFieldBoosts(map[string]float64{"alt": -1}); err == nil {
+		t.Error("Expected an error for a negative boost")
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	testCases := []struct {
+		query string
+		pos   int
+	}{
+		{query: "barrel AND", pos: 11},
+		{query: "OR barrel", pos: 1},
+		{query: "(barrel island", pos: 1},
+		{query: "barrel island)", pos: 14},
+		{query: `boy "horse battery`, pos: 5},
+		{query: `"horse battery"~x`, pos: 17},
+		{query: "title: barrel", pos: 7},
+		{query: "num:abc", pos: 5},
+		{query: "title:alt:barrel", pos: 7},
+		{query: "barrel AND ()", pos: 12},
+		{query: "barrel NOT", pos: 11},
+		{query: "barrel OR OR island", pos: 11},
+		{query: "ääh AND", pos: 8},
+	}
+	for _, tc := range testCases {
+		_, err := ParseQuery(tc.query)
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Query %q: expected a parse error, got %v", tc.query, err)
+			continue
+		}
+		if parseErr.Pos != tc.pos {
+			t.Errorf("Query %q: expected an error at %d, got %v", tc.query, tc.pos, parseErr)
+		}
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	corpus, index := testCorpus(t)
+	corrector := words.NewCorrector(index)
+
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{query: "barel AND reaches", expected: "barrel AND reaches"},
+		{query: "title:islnd", expected: "title:island"},
+		// The correction finds nothing either.
+		{query: "barel AND staple", expected: ""},
+		{query: "volcano", expected: ""},
+	}
+	for _, tc := range testCases {
+		if got := DidYouMean(corrector, corpus, tc.query, words.FuzzyOff); got != tc.expected {
+			t.Errorf("Query %q: expected %q, got %q", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestQuerySynonyms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "synonyms.txt")
+	load := func(rules string) {
+		if err := os.WriteFile(path, []byte(rules), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := words.LoadSynonyms(path); err != nil {
+			t.Fatalf("Failed to load synonyms: %v", err)
+		}
+	}
+	load("sea => ocean\ncask, barrel\nsailing vessel, raft\n")
+	defer load("")
+	corpus, _ := testCorpus(t)
+
+	testCases := []struct {
+		query    string
+		expected []int
+	}{
+		{query: "sea", expected: []int{4}},
+		{query: "ocean", expected: []int{4}},
+		{query: "cask AND reaches", expected: []int{2}},
+		{query: `"floating in a cask" AND boy`, expected: []int{1}},
+		{query: "sailing vessel", expected: []int{4}},
+		{query: "title:cask", expected: []int{1}},
+		{query: "-cask", expected: []int{3, 4}},
+		// Comics matching the words themselves rank above their synonyms,
+		// and a synonym in the title above one in the alt text.
+		{query: "raft OR cask", expected: []int{4, 1, 2}},
+	}
+	for _, tc := range testCases {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tc.query, err)
+			continue
+		}
+		ids := []int{}
+		for _, result := range q.Search(corpus) {
+			ids = append(ids, result.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("Query %q: expected %v, got %v", tc.query, tc.expected, ids)
+		}
+	}
+}
@@ -4,33 +4,143 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
 )
 
-func HandleSearchQuery(dbFile, indexFile, query string) {
-	index, err := words.LoadIndex(indexFile)
+func HandleSearchQuery(store database.Store, indexFile, query string, fuzzy words.Fuzziness, boosts map[string]float64) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		log.Fatalf("Invalid search query: %v", err)
+	}
+	q.Fuzzy = fuzzy
+
+	index, err := database.LoadIndex(indexFile)
 	if err != nil {
 		log.Fatalf("Failed to load index: %v", err)
 	}
 
-	comics, err := database.LoadAllComics(dbFile)
+	comics, err := database.LoadAllComics(store)
 	if err != nil {
 		log.Fatalf("Failed to load comics: %v", err)
 	}
 
-	results := words.SearchIndex(query, index)
-	for i, id := range results {
+	corpus := NewCorpus(index, comics, boosts)
+	results := q.Search(corpus)
+	printResults(comics, results)
+	if len(results) == 0 {
+		if suggestion := DidYouMean(words.NewCorrector(index), corpus, query, fuzzy); suggestion != "" {
+			fmt.Printf("No comics found. Did you mean: %s\n", suggestion)
+		}
+	}
+	os.Exit(0)
+}
+
+// DidYouMean returns the query with its misspelled words corrected, if that
+// finds any comics, or "" otherwise.
+func DidYouMean(corrector *words.Corrector, corpus *Corpus, query string, fuzzy words.Fuzziness) string {
+	corrected, ok := corrector.CorrectQuery(query)
+	if !ok {
+		return ""
+	}
+	q, err := ParseQuery(corrected)
+	if err != nil {
+		return ""
+	}
+	q.Fuzzy = fuzzy
+	if len(q.Search(corpus)) == 0 {
+		return ""
+	}
+	return corrected
+}
+
+// HandleSearchAsOf searches the comics as they were at the given time.
+func HandleSearchAsOf(store database.Store, revisions *database.RevisionLog, query string, at time.Time, fuzzy words.Fuzziness, boosts map[string]float64) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		log.Fatalf("Invalid search query: %v", err)
+	}
+	q.Fuzzy = fuzzy
+
+	comics, err := revisions.AsOf(store, at)
+	if err != nil {
+		log.Fatalf("Failed to load revisions: %v", err)
+	}
+
+	printResults(comics, q.Search(NewCorpus(database.MakeIndex(comics), comics, boosts)))
+	os.Exit(0)
+}
+
+// SearchAsOf returns the comics matching the query as they were at the given
+// time, best matches first. A syntax error is returned as a *ParseError.
+func SearchAsOf(store database.Store, revisions *database.RevisionLog, query string, at time.Time, fuzzy words.Fuzziness, boosts map[string]float64) ([]Hit, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q.Fuzzy = fuzzy
+
+	comics, err := revisions.AsOf(store, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revisions: %v", err)
+	}
+	return resultHits(comics, q.Search(NewCorpus(database.MakeIndex(comics), comics, boosts))), nil
+}
+
+// ParseTime parses a point in time given as RFC 3339 or as a date. A date
+// stands for its end, so that everything from that day is included.
+func ParseTime(value string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+func printResults(comics map[int]*database.ComicKeywords, results []words.Result) {
+	for i, result := range results {
 		if i >= 10 {
 			break
 		}
-		comic, ok := comics[id]
+		comic, ok := comics[result.ID]
 		if !ok {
-			log.Printf("Failed to get comic %d: comic not found", id)
+			log.Printf("Failed to get comic %d: comic not found", result.ID)
 			continue
 		}
-		fmt.Printf("Comic ID: %d, URL: %s, Page URL: https://xkcd.com/%d\n", comic.Num, comic.Img, comic.Num)
+		if comic.Title != "" {
+			fmt.Printf("Comic ID: %d, Title: %s, Date: %s, URL: %s, Page URL: https://xkcd.com/%d\n",
+				comic.Num, comic.Title, comic.Date, comic.Img, comic.Num)
+		} else {
+			fmt.Printf("Comic ID: %d, URL: %s, Page URL: https://xkcd.com/%d\n", comic.Num, comic.Img, comic.Num)
+		}
+		if len(result.Fields) > 0 {
+			fmt.Printf("  Score: %.2f (%s)\n", result.Score, formatFields(result.Fields))
+		}
 	}
-	os.Exit(0)
+}
+
+// formatFields lists the field scores of a result, the highest first.
+func formatFields(fields map[string]float64) string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if fields[names[i]] != fields[names[j]] {
+			return fields[names[i]] > fields[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, field := range names {
+		parts[i] = fmt.Sprintf("%s %.2f", field, fields[field])
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/words"
+)
+
+const benchmarkQuery = "I'm following your questions"
+
+// loadComics reads the stored comics.
+func loadComics(b *testing.B) map[int]*database.ComicKeywords {
+	data, err := os.ReadFile("../database/database.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var stored []database.ComicKeywords
+	if err := json.Unmarshal(data, &stored); err != nil {
+		b.Fatal(err)
+	}
+	comics := make(map[int]*database.ComicKeywords, len(stored))
+	for i := range stored {
+		comics[stored[i].Num] = &stored[i]
+	}
+	return comics
+}
+
+// benchmarkSearch runs benchmarkQuery over the stored comics and index.
+func benchmarkSearch(b *testing.B, index words.PostingsSource, comics map[int]*database.ComicKeywords) {
+	if err := words.LoadStopWords("../words/stopwords.txt"); err != nil {
+		b.Fatal(err)
+	}
+	q, err := ParseQuery(benchmarkQuery)
+	if err != nil {
+		b.Fatal(err)
+	}
+	corpus := NewCorpus(index, comics, nil)
+	corpus.IndexFields()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = q.Search(corpus)
+	}
+}
+
+func BenchmarkSearchByIndex(b *testing.B) {
+	comics := loadComics(b)
+	benchmarkSearch(b, database.MakeIndex(comics), comics)
+}
+
+func BenchmarkSearchByBinaryIndex(b *testing.B) {
+	comics := loadComics(b)
+	decoded, err := words.DecodeBinaryIndex(words.EncodeBinaryIndex(database.MakeIndex(comics)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSearch(b, decoded, comics)
+}
+
+func BenchmarkSearchByMappedIndex(b *testing.B) {
+	comics := loadComics(b)
+	indexFile := filepath.Join(b.TempDir(), "index.bin")
+	if err := words.NewIndexWriter(indexFile).Replace(database.MakeIndex(comics)); err != nil {
+		b.Fatal(err)
+	}
+	index, err := words.OpenMappedIndex(indexFile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer index.Close()
+	benchmarkSearch(b, index, comics)
+}
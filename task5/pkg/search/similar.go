@@ -0,0 +1,87 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/database"
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/images"
+)
+
+const (
+	// DefaultSimilarLimit is how many similar comics are returned by default.
+	DefaultSimilarLimit = 10
+	// DefaultSimilarDistance is the default largest Hamming distance, out of
+	// 64 bits, at which images still count as similar.
+	DefaultSimilarDistance = 10
+)
+
+var ErrNoImageHash = errors.New("comic has no image hash, mirror its image first")
+
+// MakeImageTree builds the BK-tree of the perceptual hashes of the given comics.
+func MakeImageTree(comics map[int]*database.ComicKeywords) *images.BKTree {
+	tree := &images.BKTree{}
+	for num, comic := range comics {
+		if comic.ImageDHash == "" {
+			continue
+		}
+		hash, err := images.ParseHash(comic.ImageDHash)
+		if err != nil {
+			log.Printf("Invalid image hash of comic %d: %v", num, err)
+			continue
+		}
+		tree.Add(hash, num)
+	}
+	return tree
+}
+
+// SimilarImages returns up to limit comics whose images are at most
+// maxDistance from the image of comic num, nearest first. The comic itself
+// is left out.
+func SimilarImages(comics map[int]*database.ComicKeywords, tree *images.BKTree, num, limit, maxDistance int) ([]images.Match, error) {
+	comic, ok := comics[num]
+	if !ok {
+		return nil, database.ErrComicNotFound
+	}
+	if comic.ImageDHash == "" {
+		return nil, ErrNoImageHash
+	}
+	hash, err := images.ParseHash(comic.ImageDHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image hash of comic %d: %v", num, err)
+	}
+
+	matches := make([]images.Match, 0, limit)
+	for _, match := range tree.Search(hash, maxDistance) {
+		if len(matches) == limit {
+			break
+		}
+		if match.Num != num {
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+func HandleSimilarImages(store database.Store, num int) {
+	comics, err := database.LoadAllComics(store)
+	if err != nil {
+		log.Fatalf("Failed to load comics: %v", err)
+	}
+
+	matches, err := SimilarImages(comics, MakeImageTree(comics), num, DefaultSimilarLimit, DefaultSimilarDistance)
+	if err != nil {
+		log.Fatalf("Failed to find comics similar to %d: %v", num, err)
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No comics look like comic %d.\n", num)
+	}
+	for _, match := range matches {
+		comic := comics[match.Num]
+		fmt.Printf("Comic ID: %d, Distance: %d, Title: %s, URL: %s, Page URL: https://xkcd.com/%d\n",
+			comic.Num, match.Distance, comic.Title, comic.Img, comic.Num)
+	}
+	os.Exit(0)
+}
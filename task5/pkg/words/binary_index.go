@@ -0,0 +1,503 @@
+package words
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+// The binary index layout is:
+//
+//	magic      8 bytes, binaryIndexMagic
+//	count      uint32, number of terms
+//	postings   uint32, offset of the postings section
+//	documents  uint32, offset of the documents section
+//	forms      uint32, offset of the forms section
+//	fields     uint32, offset of the fields section
+//	offsets    count x uint32, offset of every dictionary entry
+//	dictionary per term in sorted order: uvarint term length, term,
+//	           uvarint postings offset within the postings section, uvarint postings count
+//	postings   per term: ascending comic numbers as uvarint deltas, each
+//	           followed by the uvarint term frequency, the uvarint number of
+//	           positions and the ascending positions as uvarint deltas
+//	documents  uvarint comic count, then per comic in ascending order:
+//	           uvarint delta of the comic number, uvarint keyword count
+//	forms      uvarint form count, then per form in sorted order: uvarint
+//	           form length, form, uvarint comic count and the ascending
+//	           comic numbers as uvarint deltas
+//	fields     uvarint field count, then per field in sorted order: uvarint
+//	           name length, name, uvarint index length and the index of
+//	           the field in this format
+//
+// Offsets are counted from the start of the file. The fixed width offsets
+// allow a binary search over the dictionary without decoding it, which is
+// what MappedIndex does. The offsets of a field index are counted from its
+// own start.
+const binaryIndexMagic = "XKCDIDX1"
+
+const binaryIndexHeaderSize = len(binaryIndexMagic) + 20
+
+var errNotBinaryIndex = errors.New("not a binary index")
+
+// IsBinaryIndexFile reports whether an index file should be written in the
+// binary format, which is chosen by the .bin extension.
+func IsBinaryIndexFile(path string) bool {
+	return strings.HasSuffix(path, ".bin")
+}
+
+// EncodeBinaryIndex encodes index in the binary format.
+func EncodeBinaryIndex(index Index) []byte {
+	terms := make([]string, 0, len(index.Terms))
+	for term := range index.Terms {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	var postings bytes.Buffer
+	postingOffsets := make([]int, len(terms))
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(w *bytes.Buffer, v int) {
+		n := binary.PutUvarint(buf[:], uint64(v))
+		w.Write(buf[:n])
+	}
+	for i, term := range terms {
+		postingOffsets[i] = postings.Len()
+		previous := 0
+		for _, p := range index.Terms[term] {
+			putUvarint(&postings, p.ID-previous)
+			putUvarint(&postings, p.Freq)
+			putUvarint(&postings, len(p.Positions))
+			previousPosition := 0
+			for _, position := range p.Positions {
+				putUvarint(&postings, position-previousPosition)
+				previousPosition = position
+			}
+			previous = p.ID
+		}
+	}
+
+	var dictionary bytes.Buffer
+	entryOffsets := make([]int, len(terms))
+	for i, term := range terms {
+		entryOffsets[i] = dictionary.Len()
+		putUvarint(&dictionary, len(term))
+		dictionary.WriteString(term)
+		putUvarint(&dictionary, postingOffsets[i])
+		putUvarint(&dictionary, len(index.Terms[term]))
+	}
+
+	ids := make([]int, 0, len(index.Lengths))
+	for id := range index.Lengths {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var documents bytes.Buffer
+	putUvarint(&documents, len(ids))
+	previous := 0
+	for _, id := range ids {
+		putUvarint(&documents, id-previous)
+		putUvarint(&documents, index.Lengths[id])
+		previous = id
+	}
+
+	var forms bytes.Buffer
+	putUvarint(&forms, len(index.Forms))
+	for _, form := range sortedKeys(index.Forms) {
+		putUvarint(&forms, len(form))
+		forms.WriteString(form)
+		putUvarint(&forms, len(index.Forms[form]))
+		previous := 0
+		for _, id := range index.Forms[form] {
+			putUvarint(&forms, id-previous)
+			previous = id
+		}
+	}
+
+	var fields bytes.Buffer
+	putUvarint(&fields, len(index.Fields))
+	for _, name := range sortedFields(index.Fields) {
+		field := EncodeBinaryIndex(index.Fields[name])
+		putUvarint(&fields, len(name))
+		fields.WriteString(name)
+		putUvarint(&fields, len(field))
+		fields.Write(field)
+	}
+
+	dictionaryStart := binaryIndexHeaderSize + 4*len(terms)
+	postingsStart := dictionaryStart + dictionary.Len()
+	documentsStart := postingsStart + postings.Len()
+	formsStart := documentsStart + documents.Len()
+	fieldsStart := formsStart + forms.Len()
+
+	var out bytes.Buffer
+	out.Grow(fieldsStart + fields.Len())
+	out.WriteString(binaryIndexMagic)
+	binary.Write(&out, binary.LittleEndian, uint32(len(terms)))
+	binary.Write(&out, binary.LittleEndian, uint32(postingsStart))
+	binary.Write(&out, binary.LittleEndian, uint32(documentsStart))
+	binary.Write(&out, binary.LittleEndian, uint32(formsStart))
+	binary.Write(&out, binary.LittleEndian, uint32(fieldsStart))
+	for _, offset := range entryOffsets {
+		binary.Write(&out, binary.LittleEndian, uint32(dictionaryStart+offset))
+	}
+	out.Write(dictionary.Bytes())
+	out.Write(postings.Bytes())
+	out.Write(documents.Bytes())
+	out.Write(forms.Bytes())
+	out.Write(fields.Bytes())
+	return out.Bytes()
+}
+
+// binaryIndex is a read-only view of an encoded binary index. The document
+// lengths, the forms and the headers of the fields are decoded up front, the
+// dictionaries and postings on demand. All offsets are checked when the index
+// is parsed, so that a corrupted file is reported then instead of being read
+// out of bounds later.
+type binaryIndex struct {
+	data          []byte
+	count         int
+	postingsStart int
+	postingsEnd   int
+	lengths       map[int]int
+	forms         map[string][]int
+	fields        map[string]*binaryIndex
+	stats         Stats
+}
+
+// decoder reads uvarints from a section of a binary index. Reading past the
+// end of the section or a malformed value sets err, after which all reads
+// return zero values.
+type decoder struct {
+	data    []byte
+	pos     int
+	section string
+	err     error
+}
+
+func (d *decoder) uvarint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 || v > math.MaxInt32 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return int(v)
+}
+
+// count reads the number of the values that follow. Every value takes at
+// least a byte, so a count larger than the rest of the section is corrupted.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > len(d.data)-d.pos {
+		d.fail()
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data)-d.pos {
+		d.fail()
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) fail() {
+	d.err = fmt.Errorf("corrupted binary index: truncated %s section", d.section)
+}
+
+func parseBinaryIndex(data []byte) (*binaryIndex, error) {
+	if len(data) < len(binaryIndexMagic) || string(data[:len(binaryIndexMagic)]) != binaryIndexMagic {
+		return nil, errNotBinaryIndex
+	}
+	if len(data) < binaryIndexHeaderSize {
+		return nil, fmt.Errorf("corrupted binary index: header does not fit in %d bytes", len(data))
+	}
+	header := func(i int) int {
+		return int(binary.LittleEndian.Uint32(data[len(binaryIndexMagic)+4*i:]))
+	}
+	b := &binaryIndex{data: data, count: header(0), postingsStart: header(1), postingsEnd: header(2)}
+	formsStart, fieldsStart := header(3), header(4)
+	if b.count > (len(data)-binaryIndexHeaderSize)/4 {
+		return nil, fmt.Errorf("corrupted binary index: %d terms do not fit in %d bytes", b.count, len(data))
+	}
+	dictionaryStart := binaryIndexHeaderSize + 4*b.count
+	if dictionaryStart > b.postingsStart || b.postingsStart > b.postingsEnd ||
+		b.postingsEnd > formsStart || formsStart > fieldsStart || fieldsStart > len(data) {
+		return nil, fmt.Errorf("corrupted binary index: section offsets do not fit in %d bytes", len(data))
+	}
+
+	if err := b.checkDictionary(dictionaryStart); err != nil {
+		return nil, err
+	}
+	if err := b.readDocuments(data[b.postingsEnd:formsStart]); err != nil {
+		return nil, err
+	}
+	if err := b.readForms(data[formsStart:fieldsStart]); err != nil {
+		return nil, err
+	}
+	if err := b.readFields(data[fieldsStart:]); err != nil {
+		return nil, err
+	}
+	b.stats = makeStats(b.lengths)
+	return b, nil
+}
+
+// checkDictionary checks that every dictionary entry and its postings lie
+// within their sections, which entry and postingsAt rely on.
+func (b *binaryIndex) checkDictionary(dictionaryStart int) error {
+	for i := 0; i < b.count; i++ {
+		pos := int(binary.LittleEndian.Uint32(b.data[binaryIndexHeaderSize+4*i:]))
+		if pos < dictionaryStart || pos >= b.postingsStart {
+			return fmt.Errorf("corrupted binary index: dictionary entry %d at offset %d is outside the dictionary", i, pos)
+		}
+		d := decoder{data: b.data[pos:b.postingsStart], section: "dictionary"}
+		d.bytes(d.uvarint())
+		offset, count := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			return d.err
+		}
+		if offset > b.postingsEnd-b.postingsStart {
+			return fmt.Errorf("corrupted binary index: postings of dictionary entry %d at offset %d are outside the postings section", i, offset)
+		}
+
+		d = decoder{data: b.data[b.postingsStart+offset : b.postingsEnd], section: "postings"}
+		for j := 0; j < count && d.err == nil; j++ {
+			d.uvarint()
+			d.uvarint()
+			for positions := d.count(); positions > 0; positions-- {
+				d.uvarint()
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+	}
+	return nil
+}
+
+func (b *binaryIndex) readDocuments(data []byte) error {
+	d := decoder{data: data, section: "documents"}
+	count := d.count()
+	b.lengths = make(map[int]int, count)
+	id := 0
+	for i := 0; i < count && d.err == nil; i++ {
+		id += d.uvarint()
+		b.lengths[id] = d.uvarint()
+	}
+	return d.err
+}
+
+func (b *binaryIndex) readForms(data []byte) error {
+	d := decoder{data: data, section: "forms"}
+	count := d.count()
+	b.forms = make(map[string][]int, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		form := string(d.bytes(d.uvarint()))
+		ids := make([]int, d.count())
+		id := 0
+		for j := range ids {
+			id += d.uvarint()
+			ids[j] = id
+		}
+		b.forms[form] = ids
+	}
+	return d.err
+}
+
+func (b *binaryIndex) readFields(data []byte) error {
+	d := decoder{data: data, section: "fields"}
+	count := d.count()
+	b.fields = make(map[string]*binaryIndex, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		name := string(d.bytes(d.uvarint()))
+		encoded := d.bytes(d.uvarint())
+		if d.err != nil {
+			break
+		}
+		field, err := parseBinaryIndex(encoded)
+		if err != nil {
+			return fmt.Errorf("corrupted binary index: field %q: %v", name, err)
+		}
+		b.fields[name] = field
+	}
+	return d.err
+}
+
+// entry decodes the i-th dictionary entry.
+func (b *binaryIndex) entry(i int) (term string, postingsOffset, postingsCount int) {
+	pos := int(binary.LittleEndian.Uint32(b.data[binaryIndexHeaderSize+4*i:]))
+	d := decoder{data: b.data[pos:b.postingsStart], section: "dictionary"}
+	term = string(d.bytes(d.uvarint()))
+	offset := d.uvarint()
+	return term, b.postingsStart + offset, d.uvarint()
+}
+
+func (b *binaryIndex) postingsAt(offset, count int) []Posting {
+	d := decoder{data: b.data[offset:b.postingsEnd], section: "postings"}
+	postings := make([]Posting, count)
+	previous := 0
+	for i := range postings {
+		p := Posting{ID: previous + d.uvarint(), Freq: d.uvarint()}
+		if positions := d.count(); positions > 0 {
+			p.Positions = make([]int, positions)
+			position := 0
+			for j := range p.Positions {
+				position += d.uvarint()
+				p.Positions[j] = position
+			}
+		}
+		postings[i] = p
+		previous = p.ID
+	}
+	return postings
+}
+
+func (b *binaryIndex) Postings(term string) []Posting {
+	i := sort.Search(b.count, func(i int) bool {
+		entryTerm, _, _ := b.entry(i)
+		return entryTerm >= term
+	})
+	if i == b.count {
+		return nil
+	}
+	entryTerm, offset, count := b.entry(i)
+	if entryTerm != term {
+		return nil
+	}
+	return b.postingsAt(offset, count)
+}
+
+func (b *binaryIndex) Vocabulary() []string {
+	terms := make([]string, b.count)
+	for i := range terms {
+		terms[i], _, _ = b.entry(i)
+	}
+	return terms
+}
+
+func (b *binaryIndex) DocLength(id int) int {
+	return b.lengths[id]
+}
+
+func (b *binaryIndex) Stats() Stats {
+	return b.stats
+}
+
+// Field returns the index of a single field. It is empty if the field was not indexed.
+func (b *binaryIndex) Field(name string) PostingsSource {
+	if field, ok := b.fields[name]; ok {
+		return field
+	}
+	return Index{}
+}
+
+func (b *binaryIndex) decode() Index {
+	index := Index{
+		Terms:   make(map[string][]Posting, b.count),
+		Lengths: make(map[int]int, len(b.lengths)),
+		Forms:   make(map[string][]int, len(b.forms)),
+		Fields:  make(map[string]Index, len(b.fields)),
+	}
+	for i := 0; i < b.count; i++ {
+		term, offset, count := b.entry(i)
+		index.Terms[term] = b.postingsAt(offset, count)
+	}
+	for form, ids := range b.forms {
+		index.Forms[form] = append([]int(nil), ids...)
+	}
+	for name, field := range b.fields {
+		index.Fields[name] = field.decode()
+	}
+	for id, length := range b.lengths {
+		index.Lengths[id] = length
+	}
+	return index
+}
+
+// DecodeBinaryIndex decodes an index encoded by EncodeBinaryIndex.
+func DecodeBinaryIndex(data []byte) (Index, error) {
+	b, err := parseBinaryIndex(data)
+	if err != nil {
+		return Index{}, err
+	}
+	return b.decode(), nil
+}
+
+// MappedIndex looks up postings directly in a memory-mapped binary index
+// file without decoding it. Segments of the index are not applied, so the
+// index should be merged before it is mapped.
+type MappedIndex struct {
+	*binaryIndex
+	unmap func() error
+}
+
+func OpenMappedIndex(indexFile string) (*MappedIndex, error) {
+	data, unmap, err := mapFile(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map index file: %v", err)
+	}
+	b, err := parseBinaryIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return &MappedIndex{binaryIndex: b, unmap: unmap}, nil
+}
+
+func (m *MappedIndex) Close() error {
+	return m.unmap()
+}
+
+// ConvertIndex rewrites the index at src in the format chosen by the extension of dst.
+func ConvertIndex(src, dst string) error {
+	index, err := LoadIndex(src)
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(dst, index)
+}
+
+func writeIndexFile(path string, index Index) error {
+	var data []byte
+	if IsBinaryIndexFile(path) {
+		data = EncodeBinaryIndex(index)
+	} else {
+		var err error
+		if data, err = encodeJSONIndex(index); err != nil {
+			return err
+		}
+	}
+	return files.WriteAtomic(path, data)
+}
+
+func readIndexFile(path string) (Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Index{}, fmt.Errorf("failed to open index file: %v", err)
+	}
+	index, err := DecodeBinaryIndex(data)
+	if err == nil {
+		return index, nil
+	}
+	if !errors.Is(err, errNotBinaryIndex) {
+		return Index{}, err
+	}
+	return decodeJSONIndex(data)
+}
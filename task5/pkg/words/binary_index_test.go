@@ -0,0 +1,145 @@
+package words
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryIndex(t *testing.T) {
+	index := NewIndex()
+	index.Add(3, []string{"barrel"})
+	index.Add(1, []string{"barrel", "barrel"})
+	index.Add(300, []string{"island"})
+	index.Add(2, []string{"island"})
+	index.Add(7, []string{"petit", "island"})
+	index.AddForms(7, []string{"petits", "islands"})
+	index.AddForms(2, []string{"islands"})
+	index.AddFields(7, map[string][]string{"title": {"island"}, "alt": {"petit", "petit"}})
+	index.AddFields(2, map[string][]string{"title": {"island"}, "alt": nil})
+
+	decoded, err := DecodeBinaryIndex(EncodeBinaryIndex(index))
+	if err != nil {
+		t.Fatalf("Failed to decode binary index: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, index) {
+		t.Errorf("Expected %v, got %v", index, decoded)
+	}
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "index.json")
+	data, err := encodeJSONIndex(index)
+	if err != nil {
+		t.Fatalf("Failed to encode JSON index: %v", err)
+	}
+	if fromJSON, err := decodeJSONIndex(data); err != nil || !reflect.DeepEqual(fromJSON, index) {
+		t.Errorf("Expected %v from JSON, got %v (%v)", index, fromJSON, err)
+	}
+	if err := os.WriteFile(jsonFile, data, 0666); err != nil {
+		t.Fatalf("Failed to write JSON index: %v", err)
+	}
+	binFile := filepath.Join(dir, "index.bin")
+	if err := ConvertIndex(jsonFile, binFile); err != nil {
+		t.Fatalf("Failed to convert index: %v", err)
+	}
+
+	mapped, err := OpenMappedIndex(binFile)
+	if err != nil {
+		t.Fatalf("Failed to map index: %v", err)
+	}
+	defer mapped.Close()
+
+	testCases := []struct {
+		term     string
+		expected []Posting
+	}{
+		{term: "barrel", expected: []Posting{{ID: 1, Freq: 2, Positions: []int{0, 1}}, {ID: 3, Freq: 1, Positions: []int{0}}}},
+		{term: "island", expected: []Posting{
+			{ID: 2, Freq: 1, Positions: []int{0}}, {ID: 7, Freq: 1, Positions: []int{1}}, {ID: 300, Freq: 1, Positions: []int{0}},
+		}},
+		{term: "petit", expected: []Posting{{ID: 7, Freq: 1, Positions: []int{0}}}},
+		{term: "absent", expected: nil},
+		{term: "a", expected: nil},
+		{term: "zzz", expected: nil},
+	}
+	for _, tc := range testCases {
+		if got := mapped.Postings(tc.term); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Mapped postings of %q: expected %v, got %v", tc.term, tc.expected, got)
+		}
+	}
+	if mapped.DocLength(1) != 2 || mapped.DocLength(7) != 2 || mapped.Stats() != index.Stats() {
+		t.Errorf("Expected the document lengths to be mapped, got %v", mapped.Stats())
+	}
+	title := mapped.Field("title")
+	if got := title.Postings("island"); !reflect.DeepEqual(got, index.Fields["title"].Postings("island")) {
+		t.Errorf("Expected the title postings to be mapped, got %v", got)
+	}
+	if mapped.Field("alt").DocLength(7) != 2 || mapped.Field("alt").DocLength(2) != 0 {
+		t.Errorf("Expected the alt lengths to be mapped, got %v", mapped.Field("alt").Stats())
+	}
+	if got := mapped.Field("transcript").Postings("island"); got != nil {
+		t.Errorf("Expected no postings of a field that was not indexed, got %v", got)
+	}
+
+	loaded, err := LoadIndex(binFile)
+	if err != nil {
+		t.Fatalf("Failed to load binary index: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, decoded) {
+		t.Errorf("Expected LoadIndex to read the binary format")
+	}
+}
+
+func TestCorruptedBinaryIndex(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, []string{"barrel", "barrel", "island"})
+	index.Add(7, []string{"petit", "island"})
+	index.AddForms(7, []string{"islands"})
+	index.AddFields(7, map[string][]string{"title": {"island"}})
+	data := EncodeBinaryIndex(index)
+
+	// Every section is read to its end, so any truncation is noticed.
+	for n := 0; n < len(data); n++ {
+		if _, err := DecodeBinaryIndex(data[:n]); err == nil {
+			t.Errorf("Expected an error for the index truncated to %d bytes", n)
+		}
+	}
+
+	// A corrupted byte may go unnoticed, but must not make reading panic.
+	for i := range data {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0xff
+		b, err := parseBinaryIndex(corrupted)
+		if err != nil {
+			continue
+		}
+		b.decode()
+		for _, term := range []string{"barrel", "island", "petit", "zzz"} {
+			b.Postings(term)
+			b.Field("title").Postings(term)
+		}
+	}
+}
+
+func TestLegacyIndexFormat(t *testing.T) {
+	// The first JSON format has no positions, no forms and no fields.
+	expected := Index{
+		Terms:   map[string][]Posting{"barrel": {{ID: 1, Freq: 2}, {ID: 3, Freq: 1}}},
+		Lengths: map[int]int{1: 2, 3: 1},
+		Forms:   map[string][]int{},
+		Fields:  map[string]Index{},
+	}
+
+	decoded, err := decodeJSONIndex([]byte(`{"barrel": [3, 1, 1]}`))
+	if err != nil {
+		t.Fatalf("Failed to decode legacy JSON index: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Legacy JSON: expected %v, got %v", expected, decoded)
+	}
+
+	if _, err := decodeJSONIndex([]byte(`{"version": 3, "terms": {}}`)); err == nil {
+		t.Errorf("Expected an error for an unsupported version")
+	}
+}
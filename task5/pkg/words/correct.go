@@ -0,0 +1,86 @@
+package words
+
+import (
+	"strings"
+)
+
+// Corrector corrects the spelling of query words that are not in an index
+// to the words of the index, see NewSuggester for which words those are.
+type Corrector struct {
+	index Index
+	dict  *Dictionary
+	docs  map[string]int
+}
+
+func NewCorrector(index Index) *Corrector {
+	words := surfaceWords(index)
+	terms := make([]string, len(words))
+	docs := make(map[string]int, len(words))
+	for i, word := range words {
+		terms[i] = word.Text
+		docs[word.Text] = word.Docs
+	}
+	return &Corrector{index: index, dict: NewDictionary(terms), docs: docs}
+}
+
+// Correct returns the correction of a word whose keyword is not in the
+// index: the nearest word, the one in the most comics among equally near
+// ones. Stop words, words of the index and words with no near word are not
+// corrected. The edits allowed scale with the length of the word as with
+// FuzzyAuto.
+func (c *Corrector) Correct(word string) (string, bool) {
+	keywords := NormalizeInput(word)
+	if len(keywords) != 1 || len(c.index.Terms[keywords[0]]) > 0 {
+		return "", false
+	}
+	similar := c.dict.Lookup(strings.ToLower(word), FuzzyAuto.MaxEdits(word))
+	if len(similar) == 0 {
+		// Terms indexed without forms are only near the stem of the word.
+		similar = c.dict.Lookup(keywords[0], FuzzyAuto.MaxEdits(keywords[0]))
+	}
+	if len(similar) == 0 {
+		return "", false
+	}
+	best := similar[0]
+	for _, s := range similar[1:] {
+		if s.Distance > best.Distance {
+			break
+		}
+		if c.docs[s.Term] > c.docs[best.Term] {
+			best = s
+		}
+	}
+	return best.Term, true
+}
+
+// CorrectQuery corrects every word of a query, leaving everything else,
+// including the upper case operators of search queries, as it is. Words
+// followed by a colon are taken for field names and kept. It reports
+// whether anything was corrected.
+func (c *Corrector) CorrectQuery(query string) (string, bool) {
+	var corrected strings.Builder
+	changed := false
+	last := 0
+	for _, loc := range re.FindAllStringIndex(query, -1) {
+		start, end := loc[0], loc[1]
+		for start < end && query[start] == '-' {
+			start++
+		}
+		switch word := query[start:end]; {
+		case word == "", word == "AND", word == "OR", word == "NOT":
+			continue
+		case end < len(query) && query[end] == ':':
+			continue
+		}
+		correction, ok := c.Correct(query[start:end])
+		if !ok {
+			continue
+		}
+		corrected.WriteString(query[last:start])
+		corrected.WriteString(correction)
+		last = end
+		changed = true
+	}
+	corrected.WriteString(query[last:])
+	return corrected.String(), changed
+}
@@ -0,0 +1,38 @@
+package words
+
+import "testing"
+
+func TestCorrector(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, []string{"python", "recurs"})
+	index.Add(2, []string{"python"})
+	index.Add(3, []string{"phyton"})
+	index.AddForms(1, []string{"python", "recursion"})
+	index.AddForms(2, []string{"python"})
+	index.AddForms(3, []string{"phyton"})
+	index.Add(4, []string{"volcano"})
+	corrector := NewCorrector(index)
+
+	testCases := []struct {
+		query    string
+		expected string
+		changed  bool
+	}{
+		// Both are one edit away, python is in more comics.
+		{query: "pyhton", expected: "python", changed: true},
+		{query: "recurssion", expected: "recursion", changed: true},
+		{query: "Recursion AND pyhton", expected: "Recursion AND python", changed: true},
+		{query: `-pyhton "recurssion"~2 title:(pyhton)`, expected: `-python "recursion"~2 title:(python)`, changed: true},
+		{query: "python", expected: "python", changed: false},
+		{query: "volcano", expected: "volcano", changed: false},
+		// volcano has no known form, so the stem of the word is corrected.
+		{query: "volcanoees", expected: "volcano", changed: true},
+		{query: "ox", expected: "ox", changed: false},
+	}
+	for _, tc := range testCases {
+		got, changed := corrector.CorrectQuery(tc.query)
+		if got != tc.expected || changed != tc.changed {
+			t.Errorf("CorrectQuery(%q): expected %q, %v, got %q, %v", tc.query, tc.expected, tc.changed, got, changed)
+		}
+	}
+}
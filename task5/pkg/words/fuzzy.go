@@ -0,0 +1,242 @@
+package words
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// fuzzyWeight scales the score of a fuzzy match once per edit.
+	fuzzyWeight = 0.5
+	// maxFuzzyExpansions bounds the index terms a query term is expanded to.
+	maxFuzzyExpansions = 10
+	// maxFuzzyEdits bounds the edits a fixed fuzziness allows.
+	maxFuzzyEdits = 2
+)
+
+// Fuzziness is how many edits a query term that is not in the index may be
+// away from the index terms it is expanded to.
+type Fuzziness int
+
+const (
+	// FuzzyOff turns fuzzy matching off.
+	FuzzyOff Fuzziness = 0
+	// FuzzyAuto allows no edits for terms shorter than 3 characters, one
+	// edit up to 5 characters and two for longer terms.
+	FuzzyAuto Fuzziness = -1
+)
+
+// ParseFuzziness parses "auto", "off" or a number of edits. An empty value is "auto".
+func ParseFuzziness(value string) (Fuzziness, error) {
+	switch strings.ToLower(value) {
+	case "", "auto", "true":
+		return FuzzyAuto, nil
+	case "off", "false":
+		return FuzzyOff, nil
+	}
+	edits, err := strconv.Atoi(value)
+	if err != nil || edits < 0 || edits > maxFuzzyEdits {
+		return FuzzyOff, fmt.Errorf("fuzziness must be auto, off or a number of edits from 0 to %d", maxFuzzyEdits)
+	}
+	return Fuzziness(edits), nil
+}
+
+// MaxEdits returns how many edits the fuzziness allows for term.
+func (f Fuzziness) MaxEdits(term string) int {
+	if f != FuzzyAuto {
+		return int(f)
+	}
+	switch n := len([]rune(term)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	}
+	return 2
+}
+
+func (f Fuzziness) String() string {
+	switch f {
+	case FuzzyAuto:
+		return "auto"
+	case FuzzyOff:
+		return "off"
+	}
+	return strconv.Itoa(int(f))
+}
+
+// Dictionary finds the terms of an index that are similar to a term. It
+// keeps the trigrams of every term, so that only terms sharing enough of
+// them with a query term need their edit distance computed.
+type Dictionary struct {
+	terms    [][]rune
+	trigrams map[string][]int
+}
+
+// Similar is a term of a dictionary and its edit distance to a query term.
+type Similar struct {
+	Term     string
+	Distance int
+}
+
+func NewDictionary(terms []string) *Dictionary {
+	d := &Dictionary{terms: make([][]rune, 0, len(terms)), trigrams: make(map[string][]int)}
+	sorted := append([]string(nil), terms...)
+	sort.Strings(sorted)
+	for i, term := range sorted {
+		d.terms = append(d.terms, []rune(term))
+		seen := make(map[string]bool)
+		for _, trigram := range trigrams(term) {
+			if !seen[trigram] {
+				seen[trigram] = true
+				d.trigrams[trigram] = append(d.trigrams[trigram], i)
+			}
+		}
+	}
+	return d
+}
+
+// Lookup returns the terms at most maxEdits edits away from term, nearest
+// first and alphabetically among equally near ones. Swapping two adjacent
+// characters counts as one edit.
+func (d *Dictionary) Lookup(term string, maxEdits int) []Similar {
+	if maxEdits <= 0 {
+		return nil
+	}
+	query := []rune(term)
+	grams := trigrams(term)
+
+	// An edit changes at most 3 trigrams, a transposition 4, so terms
+	// sharing fewer cannot be near enough. If that bound is not positive,
+	// every term has to be checked.
+	var candidates []int
+	if minShared := len(grams) - 4*maxEdits; minShared > 0 {
+		shared := make(map[int]int)
+		seen := make(map[string]bool)
+		for _, trigram := range grams {
+			if seen[trigram] {
+				continue
+			}
+			seen[trigram] = true
+			for _, i := range d.trigrams[trigram] {
+				shared[i]++
+			}
+		}
+		for i, count := range shared {
+			if count >= minShared {
+				candidates = append(candidates, i)
+			}
+		}
+		sort.Ints(candidates)
+	} else {
+		candidates = make([]int, len(d.terms))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+
+	var similar []Similar
+	for _, i := range candidates {
+		candidate := d.terms[i]
+		if diff := len(candidate) - len(query); diff > maxEdits || -diff > maxEdits {
+			continue
+		}
+		if distance := editDistance(query, candidate, maxEdits); distance <= maxEdits {
+			similar = append(similar, Similar{Term: string(candidate), Distance: distance})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar
+}
+
+// trigrams returns the trigrams of term padded with a $ on both sides.
+func trigrams(term string) []string {
+	padded := []rune("$" + term + "$")
+	grams := make([]string, 0, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		grams = append(grams, string(padded[i:i+3]))
+	}
+	return grams
+}
+
+// editDistance returns the optimal string alignment distance of a and b:
+// insertions, deletions, substitutions and transpositions of adjacent
+// characters. Once it exceeds limit, limit+1 is returned.
+func editDistance(a, b []rune, limit int) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// FuzzyResults scores the comics containing the index terms similar to a
+// term that is not in the index. Every edit halves the score of a match and
+// only the nearest terms are used, the more frequent first among equally
+// near ones. dict lists the terms of index.
+func FuzzyResults(index PostingsSource, dict *Dictionary, term string, fuzzy Fuzziness) []Result {
+	similar := dict.Lookup(term, fuzzy.MaxEdits(term))
+	postings := make(map[string][]Posting, len(similar))
+	for _, s := range similar {
+		postings[s.Term] = index.Postings(s.Term)
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return len(postings[similar[i].Term]) > len(postings[similar[j].Term])
+	})
+	if len(similar) > maxFuzzyExpansions {
+		similar = similar[:maxFuzzyExpansions]
+	}
+
+	scores := make(map[int]float64)
+	var ids []int
+	for _, s := range similar {
+		weight := math.Pow(fuzzyWeight, float64(s.Distance))
+		for _, result := range ScorePostings(index, postings[s.Term]) {
+			if _, ok := scores[result.ID]; !ok {
+				ids = append(ids, result.ID)
+			}
+			scores[result.ID] += weight * result.Score
+		}
+	}
+	results := make([]Result, len(ids))
+	for i, id := range ids {
+		results[i] = Result{ID: id, Score: scores[id]}
+	}
+	return results
+}
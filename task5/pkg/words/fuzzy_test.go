@@ -0,0 +1,129 @@
+package words
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFuzziness(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected Fuzziness
+		err      bool
+	}{
+		{value: "", expected: FuzzyAuto},
+		{value: "auto", expected: FuzzyAuto},
+		{value: "OFF", expected: FuzzyOff},
+		{value: "0", expected: FuzzyOff},
+		{value: "2", expected: 2},
+		{value: "3", err: true},
+		{value: "-1", err: true},
+		{value: "some", err: true},
+	}
+	for _, tc := range testCases {
+		fuzzy, err := ParseFuzziness(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Value %q: expected an error, got %v", tc.value, fuzzy)
+			}
+			continue
+		}
+		if err != nil || fuzzy != tc.expected {
+			t.Errorf("Value %q: expected %v, got %v (%v)", tc.value, tc.expected, fuzzy, err)
+		}
+	}
+}
+
+func TestMaxEdits(t *testing.T) {
+	testCases := []struct {
+		fuzzy    Fuzziness
+		term     string
+		expected int
+	}{
+		{fuzzy: FuzzyAuto, term: "ox", expected: 0},
+		{fuzzy: FuzzyAuto, term: "cat", expected: 1},
+		{fuzzy: FuzzyAuto, term: "horse", expected: 1},
+		{fuzzy: FuzzyAuto, term: "pyhton", expected: 2},
+		{fuzzy: FuzzyOff, term: "pyhton", expected: 0},
+		{fuzzy: 1, term: "ox", expected: 1},
+	}
+	for _, tc := range testCases {
+		if got := tc.fuzzy.MaxEdits(tc.term); got != tc.expected {
+			t.Errorf("MaxEdits(%q) with %v: expected %d, got %d", tc.term, tc.fuzzy, tc.expected, got)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "python", b: "python", expected: 0},
+		{a: "pyhton", b: "python", expected: 1},
+		{a: "recurssion", b: "recursion", expected: 1},
+		{a: "kitten", b: "sitting", expected: 3},
+		{a: "", b: "abc", expected: 3},
+	}
+	for _, tc := range testCases {
+		if got := editDistance([]rune(tc.a), []rune(tc.b), 5); got != tc.expected {
+			t.Errorf("editDistance(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, got)
+		}
+	}
+	if got := editDistance([]rune("kitten"), []rune("sitting"), 1); got != 2 {
+		t.Errorf("Expected the distance to stop at the limit, got %d", got)
+	}
+}
+
+func TestDictionaryLookup(t *testing.T) {
+	dict := NewDictionary([]string{"python", "pythons", "phyton", "recursion", "raptor", "cat", "cut"})
+
+	testCases := []struct {
+		term     string
+		maxEdits int
+		expected []Similar
+	}{
+		{term: "pyhton", maxEdits: 2, expected: []Similar{{"phyton", 1}, {"python", 1}, {"pythons", 2}}},
+		{term: "pyhton", maxEdits: 1, expected: []Similar{{"phyton", 1}, {"python", 1}}},
+		{term: "recurssion", maxEdits: 2, expected: []Similar{{"recursion", 1}}},
+		{term: "cot", maxEdits: 1, expected: []Similar{{"cat", 1}, {"cut", 1}}},
+		{term: "cot", maxEdits: 0, expected: nil},
+	}
+	for _, tc := range testCases {
+		if got := dict.Lookup(tc.term, tc.maxEdits); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Lookup(%q, %d): expected %v, got %v", tc.term, tc.maxEdits, tc.expected, got)
+		}
+	}
+}
+
+func TestFuzzyResults(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, []string{"python", "code"})
+	index.Add(2, []string{"recurs", "code"})
+	index.Add(3, []string{"pythons", "plant"})
+	dict := NewDictionary(index.Vocabulary())
+
+	ids := func(results []Result) []int {
+		SortResults(results)
+		ids := []int{}
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		return ids
+	}
+
+	// One edit away ranks above two edits away.
+	if got := ids(FuzzyResults(index, dict, "pyhton", FuzzyAuto)); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("Expected [1 3], got %v", got)
+	}
+	if got := ids(FuzzyResults(index, dict, "pyhton", FuzzyOff)); len(got) != 0 {
+		t.Errorf("Expected no results with fuzzy matching off, got %v", got)
+	}
+
+	// A fuzzy match scores half of an exact one per edit.
+	exact := ScorePostings(index, index.Postings("python"))[0].Score
+	fuzzy := FuzzyResults(index, dict, "pyhton", 1)[0].Score
+	if fuzzy != exact*fuzzyWeight {
+		t.Errorf("Expected score %v, got %v", exact*fuzzyWeight, fuzzy)
+	}
+}
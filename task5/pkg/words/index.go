@@ -0,0 +1,398 @@
+package words
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// jsonIndexVersion is the version of the JSON index format. Version 1 maps
+// every term to a comic number per occurrence and has no version field.
+const jsonIndexVersion = 2
+
+// IndexFormat identifies what an index records about the keywords of a
+// comic. Bump it when that changes, so that indexes built without the new
+// details get rebuilt.
+const IndexFormat = 1
+
+// Posting is an occurrence of a term in a comic.
+type Posting struct {
+	ID int
+	// Freq is how many times the term occurs among the keywords of the comic.
+	Freq int
+	// Positions are the ascending positions of the term among the keywords.
+	// They are missing from indexes of older formats.
+	Positions []int
+}
+
+// Index is an inverted keyword index with the statistics needed to rank
+// matches. Postings of every term are sorted by comic number.
+type Index struct {
+	Terms map[string][]Posting
+	// Lengths is the number of keywords of every indexed comic.
+	Lengths map[int]int
+	// Forms maps the words the keywords were stemmed from, lower-cased, to
+	// the sorted numbers of the comics containing them. They are missing
+	// from indexes of older formats.
+	Forms map[string][]int
+	// Fields index the keywords of single fields of the comics, such as the
+	// title, by field name. They are missing from indexes of older formats.
+	Fields map[string]Index
+}
+
+func NewIndex() Index {
+	return Index{
+		Terms:   make(map[string][]Posting),
+		Lengths: make(map[int]int),
+		Forms:   make(map[string][]int),
+		Fields:  make(map[string]Index),
+	}
+}
+
+// Add indexes the keywords of a comic that is not in the index yet.
+// Comics without keywords are left out.
+func (index Index) Add(id int, keywords []string) {
+	if len(keywords) == 0 {
+		return
+	}
+	positions := make(map[string][]int, len(keywords))
+	for i, keyword := range keywords {
+		positions[keyword] = append(positions[keyword], i)
+	}
+	for term, termPositions := range positions {
+		p := Posting{ID: id, Freq: len(termPositions), Positions: termPositions}
+		index.Terms[term] = insertPosting(index.Terms[term], p)
+	}
+	index.Lengths[id] += len(keywords)
+}
+
+// AddForms records the surface forms of the keywords of a comic, see SurfaceForms.
+func (index Index) AddForms(id int, forms []string) {
+	for _, form := range forms {
+		index.Forms[form] = insertID(index.Forms[form], id)
+	}
+}
+
+// AddFields indexes the keywords of the fields of a comic, by field name.
+func (index Index) AddFields(id int, fields map[string][]string) {
+	for name, keywords := range fields {
+		if len(keywords) == 0 {
+			continue
+		}
+		field, ok := index.Fields[name]
+		if !ok {
+			field = NewIndex()
+			index.Fields[name] = field
+		}
+		field.Add(id, keywords)
+	}
+}
+
+// Field returns the index of a single field. It is empty if the field was not indexed.
+func (index Index) Field(name string) PostingsSource {
+	return index.Fields[name]
+}
+
+// insertID adds id to sorted ids unless it is there already.
+func insertID(ids []int, id int) []int {
+	if n := len(ids); n == 0 || ids[n-1] < id {
+		return append(ids, id)
+	}
+	i := sort.SearchInts(ids, id)
+	if ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// insertPosting adds p to postings sorted by comic number. Comics are
+// usually added in order, so appending is the common case.
+func insertPosting(postings []Posting, p Posting) []Posting {
+	if n := len(postings); n == 0 || postings[n-1].ID < p.ID {
+		return append(postings, p)
+	}
+	i := sort.Search(len(postings), func(i int) bool { return postings[i].ID >= p.ID })
+	if postings[i].ID == p.ID {
+		postings[i].Freq += p.Freq
+		postings[i].Positions = append(postings[i].Positions, p.Positions...)
+		sort.Ints(postings[i].Positions)
+		return postings
+	}
+	postings = append(postings, Posting{})
+	copy(postings[i+1:], postings[i:])
+	postings[i] = p
+	return postings
+}
+
+// remove drops the postings of the comics for which drop returns true.
+func (index Index) remove(drop func(id int) bool) {
+	for term, postings := range index.Terms {
+		kept := postings[:0]
+		for _, p := range postings {
+			if !drop(p.ID) {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(index.Terms, term)
+			continue
+		}
+		index.Terms[term] = kept
+	}
+	for id := range index.Lengths {
+		if drop(id) {
+			delete(index.Lengths, id)
+		}
+	}
+	for form, ids := range index.Forms {
+		kept := ids[:0]
+		for _, id := range ids {
+			if !drop(id) {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(index.Forms, form)
+			continue
+		}
+		index.Forms[form] = kept
+	}
+	for name, field := range index.Fields {
+		field.remove(drop)
+		if len(field.Lengths) == 0 {
+			delete(index.Fields, name)
+		}
+	}
+}
+
+func (index Index) Postings(term string) []Posting {
+	return index.Terms[term]
+}
+
+func (index Index) Vocabulary() []string {
+	terms := make([]string, 0, len(index.Terms))
+	for term := range index.Terms {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+func (index Index) DocLength(id int) int {
+	return index.Lengths[id]
+}
+
+func (index Index) Stats() Stats {
+	return makeStats(index.Lengths)
+}
+
+// Documents inverts the index back into the sorted keywords of every comic.
+func (index Index) Documents() map[int][]string {
+	documents := make(map[int][]string)
+	for term, postings := range index.Terms {
+		for _, p := range postings {
+			for i := 0; i < p.Freq; i++ {
+				documents[p.ID] = append(documents[p.ID], term)
+			}
+		}
+	}
+	for _, keywords := range documents {
+		sort.Strings(keywords)
+	}
+	return documents
+}
+
+// computeLengths fills in Lengths from the postings.
+func (index Index) computeLengths() {
+	for _, postings := range index.Terms {
+		for _, p := range postings {
+			index.Lengths[p.ID] += p.Freq
+		}
+	}
+}
+
+// jsonIndex is the JSON index format: every posting is an array of the comic
+// number, the frequency and the positions of the term. Forms lists the
+// comics containing every surface form and Fields holds the index of every
+// field in the same format, without a version.
+type jsonIndex struct {
+	Version int                  `json:"version"`
+	Terms   map[string][][]int   `json:"terms"`
+	Forms   map[string][]int     `json:"forms,omitempty"`
+	Fields  map[string]jsonIndex `json:"fields,omitempty"`
+}
+
+func decodeJSONIndex(data []byte) (Index, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Index{}, fmt.Errorf("failed to decode index: %v", err)
+	}
+	if version, ok := fields["version"]; !ok || len(version) == 0 || version[0] == '[' {
+		return decodeLegacyJSONIndex(data)
+	}
+
+	var stored jsonIndex
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return Index{}, fmt.Errorf("failed to decode index: %v", err)
+	}
+	if stored.Version != jsonIndexVersion {
+		return Index{}, fmt.Errorf("unsupported index version %d", stored.Version)
+	}
+	return stored.index()
+}
+
+func (stored jsonIndex) index() (Index, error) {
+	index := NewIndex()
+	for term, entries := range stored.Terms {
+		postings := make([]Posting, 0, len(entries))
+		for _, entry := range entries {
+			if len(entry) < 2 {
+				return Index{}, fmt.Errorf("failed to decode index: invalid posting %v of %q", entry, term)
+			}
+			p := Posting{ID: entry[0], Freq: entry[1]}
+			if len(entry) > 2 {
+				p.Positions = entry[2:]
+			}
+			postings = insertPosting(postings, p)
+		}
+		index.Terms[term] = postings
+	}
+	for form, ids := range stored.Forms {
+		for _, id := range ids {
+			index.Forms[form] = insertID(index.Forms[form], id)
+		}
+	}
+	for name, storedField := range stored.Fields {
+		field, err := storedField.index()
+		if err != nil {
+			return Index{}, err
+		}
+		index.Fields[name] = field
+	}
+	index.computeLengths()
+	return index, nil
+}
+
+// decodeLegacyJSONIndex decodes the first JSON format, which repeats the
+// number of a comic for every occurrence of a term.
+func decodeLegacyJSONIndex(data []byte) (Index, error) {
+	var legacy map[string][]int
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return Index{}, fmt.Errorf("failed to decode index: %v", err)
+	}
+	index := NewIndex()
+	for term, ids := range legacy {
+		var postings []Posting
+		for _, id := range ids {
+			postings = insertPosting(postings, Posting{ID: id, Freq: 1})
+		}
+		index.Terms[term] = postings
+	}
+	index.computeLengths()
+	return index, nil
+}
+
+// encodeJSONIndex writes every term and form on a line of its own, in sorted order.
+func encodeJSONIndex(index Index) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  \"version\": %d,", jsonIndexVersion)
+	if err := writeJSONIndex(&buf, index, "\n  "); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n}\n")
+	return buf.Bytes(), nil
+}
+
+// writeJSONIndex writes the members of an index object, every one of them
+// on a line starting with indent.
+func writeJSONIndex(buf *bytes.Buffer, index Index, indent string) error {
+	buf.WriteString(indent + "\"terms\": {")
+	for i, term := range index.Vocabulary() {
+		if err := writeJSONKey(buf, i, term, indent+"  "); err != nil {
+			return err
+		}
+		buf.WriteByte('[')
+		for j, p := range index.Terms[term] {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "[%d,%d", p.ID, p.Freq)
+			for _, position := range p.Positions {
+				fmt.Fprintf(buf, ",%d", position)
+			}
+			buf.WriteByte(']')
+		}
+		buf.WriteByte(']')
+	}
+
+	buf.WriteString(indent + "},")
+	buf.WriteString(indent + "\"forms\": {")
+	for i, form := range sortedKeys(index.Forms) {
+		if err := writeJSONKey(buf, i, form, indent+"  "); err != nil {
+			return err
+		}
+		buf.WriteByte('[')
+		for j, id := range index.Forms[form] {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%d", id)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteString(indent + "}")
+	if len(index.Fields) == 0 {
+		return nil
+	}
+
+	buf.WriteString("," + indent + "\"fields\": {")
+	for i, name := range sortedFields(index.Fields) {
+		if err := writeJSONKey(buf, i, name, indent+"  "); err != nil {
+			return err
+		}
+		buf.WriteByte('{')
+		if err := writeJSONIndex(buf, index.Fields[name], indent+"    "); err != nil {
+			return err
+		}
+		buf.WriteString(indent + "  }")
+	}
+	buf.WriteString(indent + "}")
+	return nil
+}
+
+// writeJSONKey starts the i-th member of an object on a line starting with indent.
+func writeJSONKey(buf *bytes.Buffer, i int, key, indent string) error {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %v", err)
+	}
+	if i > 0 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString(indent)
+	buf.Write(encoded)
+	buf.WriteString(": ")
+	return nil
+}
+
+func sortedKeys(forms map[string][]int) []string {
+	keys := make([]string, 0, len(forms))
+	for key := range forms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFields(fields map[string]Index) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
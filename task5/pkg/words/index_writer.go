@@ -0,0 +1,269 @@
+package words
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/files"
+)
+
+const (
+	// maxIndexSegmentSize is the size after which a new index segment is started.
+	maxIndexSegmentSize = 256 << 10
+	// maxIndexSegments is the number of index segments that makes a merge worthwhile.
+	maxIndexSegments = 4
+)
+
+// indexRecord is one change of the index kept in a segment: the full set of
+// keywords, surface forms and field keywords of a comic, replacing any
+// earlier postings of it, or its deletion.
+type indexRecord struct {
+	Generation uint64              `json:"gen"`
+	Num        int                 `json:"num"`
+	Keywords   []string            `json:"keywords,omitempty"`
+	Forms      []string            `json:"forms,omitempty"`
+	Fields     map[string][]string `json:"fields,omitempty"`
+	Deleted    bool                `json:"deleted,omitempty"`
+}
+
+// IndexWriter maintains an index as a JSON base file plus append-only NDJSON
+// segments of per-comic changes next to it, so that changing a few comics
+// does not rewrite the whole index. Segments are merged into the base file
+// once enough of them pile up.
+type IndexWriter struct {
+	path string
+
+	// Lock, if set, is held while merging in the background so that other
+	// processes are kept out of the index files.
+	Lock func() (unlock func(), err error)
+
+	mu      sync.Mutex
+	pending []indexRecord
+	merging bool
+}
+
+func NewIndexWriter(path string) *IndexWriter {
+	return &IndexWriter{path: path}
+}
+
+// Add replaces the postings and forms of a comic with its keywords, forms
+// and the keywords of its fields on the next commit.
+func (w *IndexWriter) Add(num int, keywords, forms []string, fields map[string][]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, indexRecord{Num: num, Keywords: keywords, Forms: forms, Fields: fields})
+}
+
+// Delete removes the postings of a comic on the next commit.
+func (w *IndexWriter) Delete(num int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, indexRecord{Num: num, Deleted: true})
+}
+
+// Commit appends the pending changes tagged with generation to the newest segment.
+func (w *IndexWriter) Commit(generation uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range w.pending {
+		record.Generation = generation
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to encode index record of comic %d: %v", record.Num, err)
+		}
+	}
+
+	segments, err := files.Segments(w.path)
+	if err != nil {
+		return err
+	}
+	active := files.ActiveSegment(w.path, segments, maxIndexSegmentSize)
+	if err := files.AppendSynced(active, buf.Bytes()); err != nil {
+		return err
+	}
+	w.pending = nil
+	return nil
+}
+
+// Replace writes index as the new base file and drops all segments.
+func (w *IndexWriter) Replace(index Index) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = nil
+	return w.replace(index)
+}
+
+// Merge folds all segments into the base file.
+func (w *IndexWriter) Merge() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	index, err := LoadIndex(w.path)
+	if err != nil {
+		return err
+	}
+	return w.replace(index)
+}
+
+// MergeInBackground starts a merge in its own goroutine if enough segments
+// have piled up and no merge is running yet.
+func (w *IndexWriter) MergeInBackground() {
+	w.mu.Lock()
+	segments, err := files.Segments(w.path)
+	if err != nil || len(segments) < maxIndexSegments || w.merging {
+		w.mu.Unlock()
+		return
+	}
+	w.merging = true
+	w.mu.Unlock()
+
+	go func() {
+		if err := w.lockedMerge(); err != nil {
+			log.Printf("Failed to merge index segments of %s: %v", w.path, err)
+		}
+		w.mu.Lock()
+		w.merging = false
+		w.mu.Unlock()
+	}()
+}
+
+func (w *IndexWriter) lockedMerge() error {
+	if w.Lock != nil {
+		unlock, err := w.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return w.Merge()
+}
+
+// Rollback drops the records of segments committed after generation.
+// Such records belong to a commit that never became current.
+func (w *IndexWriter) Rollback(generation uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segments, err := files.Segments(w.path)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		end, err := readIndexSegment(segment, func(record indexRecord, _ int64) bool {
+			return record.Generation <= generation
+		})
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(segment)
+		if err != nil {
+			return err
+		}
+		if info.Size() == end {
+			continue
+		}
+		if err := os.Truncate(segment, end); err != nil {
+			return fmt.Errorf("failed to roll back index segment %s: %v", segment, err)
+		}
+	}
+	return nil
+}
+
+func (w *IndexWriter) replace(index Index) error {
+	segments, err := files.Segments(w.path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeIndexFile(w.path, index); err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove index segment %s: %v", segment, err)
+		}
+	}
+	return nil
+}
+
+// applyIndexSegments replays the segments of the index at path over index.
+func applyIndexSegments(path string, index Index) error {
+	segments, err := files.Segments(path)
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[int]indexRecord)
+	for _, segment := range segments {
+		_, err := readIndexSegment(segment, func(record indexRecord, _ int64) bool {
+			latest[record.Num] = record
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+
+	index.remove(func(id int) bool {
+		_, ok := latest[id]
+		return ok
+	})
+	for num, record := range latest {
+		if !record.Deleted {
+			index.Add(num, record.Keywords)
+			index.AddForms(num, record.Forms)
+			index.AddFields(num, record.Fields)
+		}
+	}
+	return nil
+}
+
+// readIndexSegment calls fn with every complete record of a segment and the
+// offset it starts at, until fn returns false. It returns the offset at which
+// reading stopped: the end of the last accepted record, which is where a torn
+// tail or the rejected record begins.
+func readIndexSegment(segment string, fn func(record indexRecord, offset int64) bool) (int64, error) {
+	file, err := os.Open(segment)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open index segment %s: %v", segment, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return offset, fmt.Errorf("failed to read index segment %s: %v", segment, err)
+		}
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			return offset, nil
+		}
+
+		var record indexRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return offset, nil
+		}
+		if !fn(record, offset) {
+			return offset, nil
+		}
+		offset += int64(len(line))
+	}
+}
@@ -1,11 +1,7 @@
 package words
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -13,19 +9,47 @@ import (
 	"github.com/kljensen/snowball/english"
 )
 
-var re = regexp.MustCompile(`[\p{L}-]+`)
+// AnalyzerVersion identifies the output of NormalizeInput. Bump it whenever
+// the tokenizer, stop words handling or stemming changes so that stored
+// keywords and indexes built by an older analyzer get rebuilt.
+const AnalyzerVersion = 1
 
-type Index map[string][]int
+var re = regexp.MustCompile(`[\p{L}-]+`)
 
 func NormalizeInput(input string) []string {
+	var normalizedWords []string
+	analyze(input, func(_, stem string) {
+		normalizedWords = append(normalizedWords, stem)
+	})
+	return normalizedWords
+}
+
+// SurfaceForms returns the distinct words of input that NormalizeInput turns
+// into keywords, lower-cased but not stemmed, in order of first occurrence.
+func SurfaceForms(input string) []string {
+	var forms []string
+	seen := make(map[string]bool)
+	analyze(input, func(form, _ string) {
+		if form != "" && !seen[form] {
+			seen[form] = true
+			forms = append(forms, form)
+		}
+	})
+	return forms
+}
+
+// Stem returns the keyword of a single surface form.
+func Stem(form string) string {
+	return strings.ToLower(english.Stem(form, false))
+}
+
+// analyze calls fn with every word of input that is a keyword and its stem.
+func analyze(input string, fn func(form, stem string)) {
 	if altIndex := strings.Index(input, "{{Alt:"); altIndex != -1 {
 		input = input[:altIndex]
 	}
 
-	tokens := re.FindAllString(input, -1)
-
-	var normalizedWords []string
-	for _, token := range tokens {
+	for _, token := range re.FindAllString(input, -1) {
 		if _, err := strconv.Atoi(token); err == nil {
 			continue
 		}
@@ -41,56 +65,20 @@ func NormalizeInput(input string) []string {
 			continue
 		}
 
-		stemmedToken := english.Stem(cleanedToken, false)
-		normalizedWords = append(normalizedWords, strings.ToLower(stemmedToken))
+		fn(strings.ToLower(cleanedToken), Stem(cleanedToken))
 	}
-
-	return normalizedWords
 }
 
+// LoadIndex loads an index file in either the JSON or the binary format
+// together with its segments.
 func LoadIndex(indexFile string) (Index, error) {
-	file, err := os.Open(indexFile)
+	index, err := readIndexFile(indexFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open index file: %v", err)
+		return Index{}, err
 	}
-	defer file.Close()
 
-	var index Index
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&index); err != nil {
-		return nil, fmt.Errorf("failed to decode index: %v", err)
+	if err := applyIndexSegments(indexFile, index); err != nil {
+		return Index{}, err
 	}
-
 	return index, nil
 }
-
-func SearchIndex(query string, index Index) []int {
-	words := NormalizeInput(query)
-	results := make(map[int]int)
-	for _, word := range words {
-		if ids, ok := index[word]; ok {
-			for _, id := range ids {
-				results[id]++
-			}
-		}
-	}
-
-	type kv struct {
-		Key   int
-		Value int
-	}
-	var sortedResults []kv
-	for k, v := range results {
-		sortedResults = append(sortedResults, kv{k, v})
-	}
-	sort.Slice(sortedResults, func(i, j int) bool {
-		return sortedResults[i].Value > sortedResults[j].Value
-	})
-
-	var finalResults []int
-	for _, kv := range sortedResults {
-		finalResults = append(finalResults, kv.Key)
-	}
-
-	return finalResults
-}
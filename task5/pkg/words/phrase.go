@@ -0,0 +1,173 @@
+package words
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Phrase is a sequence of terms that must occur together. Positions count
+// keywords, so stop words and numbers between the terms are not counted.
+type Phrase struct {
+	Terms []string
+	// Within, if not zero, turns the phrase into a proximity match: the terms
+	// must occur in any order within a span of that many keywords.
+	// Otherwise they must occur next to each other in order.
+	Within int
+}
+
+// Query is a free-text query: bare words and quoted phrases.
+type Query struct {
+	Terms   []string
+	Phrases []Phrase
+}
+
+// ParseQuery splits a query into words and phrases. Text in double quotes is
+// a phrase; a quote followed by ~N, as in "horse staple"~3, asks for the
+// words within N keywords of each other. The words of phrases are also
+// searched for on their own, so that comics which only contain the words
+// still match, ranked below the ones with the phrase.
+func ParseQuery(query string) Query {
+	var q Query
+	for {
+		start := strings.IndexByte(query, '"')
+		if start == -1 {
+			q.Terms = append(q.Terms, NormalizeInput(query)...)
+			return q
+		}
+		q.Terms = append(q.Terms, NormalizeInput(query[:start])...)
+		query = query[start+1:]
+
+		text := query
+		end := strings.IndexByte(query, '"')
+		if end == -1 {
+			query = ""
+		} else {
+			text, query = query[:end], query[end+1:]
+		}
+		phrase := Phrase{Terms: NormalizeInput(text)}
+		if strings.HasPrefix(query, "~") {
+			digits := len(query[1:]) - len(strings.TrimLeft(query[1:], "0123456789"))
+			phrase.Within, _ = strconv.Atoi(query[1 : 1+digits])
+			query = query[1+digits:]
+		}
+
+		q.Terms = append(q.Terms, phrase.Terms...)
+		if len(phrase.Terms) > 1 {
+			q.Phrases = append(q.Phrases, phrase)
+		}
+	}
+}
+
+// PhrasePostings returns the comics containing the phrase. Freq is the number
+// of matches and Positions are where they start. Comics indexed without
+// positions never match.
+func PhrasePostings(index PostingsSource, phrase Phrase) []Posting {
+	distinct := make([]string, 0, len(phrase.Terms))
+	postings := make(map[string][]Posting, len(phrase.Terms))
+	for _, term := range phrase.Terms {
+		if _, ok := postings[term]; ok {
+			continue
+		}
+		termPostings := index.Postings(term)
+		if len(termPostings) == 0 {
+			return nil
+		}
+		postings[term] = termPostings
+		distinct = append(distinct, term)
+	}
+	sort.Slice(distinct, func(i, j int) bool {
+		return len(postings[distinct[i]]) < len(postings[distinct[j]])
+	})
+
+	var matches []Posting
+	for _, candidate := range postings[distinct[0]] {
+		positions := make(map[string][]int, len(distinct))
+		for _, term := range distinct {
+			p, ok := findPosting(postings[term], candidate.ID)
+			if !ok || len(p.Positions) == 0 {
+				break
+			}
+			positions[term] = p.Positions
+		}
+		if len(positions) < len(distinct) {
+			continue
+		}
+
+		var starts []int
+		if phrase.Within > 0 {
+			starts = proximityMatches(distinct, positions, phrase.Within)
+		} else {
+			starts = phraseMatches(phrase.Terms, positions)
+		}
+		if len(starts) > 0 {
+			matches = append(matches, Posting{ID: candidate.ID, Freq: len(starts), Positions: starts})
+		}
+	}
+	return matches
+}
+
+func findPosting(postings []Posting, id int) (Posting, bool) {
+	i := sort.Search(len(postings), func(i int) bool { return postings[i].ID >= id })
+	if i == len(postings) || postings[i].ID != id {
+		return Posting{}, false
+	}
+	return postings[i], true
+}
+
+// phraseMatches returns the positions at which terms occur one after another.
+func phraseMatches(terms []string, positions map[string][]int) []int {
+	var starts []int
+	for _, start := range positions[terms[0]] {
+		matched := true
+		for i, term := range terms[1:] {
+			termPositions := positions[term]
+			j := sort.SearchInts(termPositions, start+i+1)
+			if j == len(termPositions) || termPositions[j] != start+i+1 {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+// proximityMatches returns the starts of the smallest windows that contain
+// every term and span at most within keywords.
+func proximityMatches(terms []string, positions map[string][]int, within int) []int {
+	type occurrence struct{ position, term int }
+	var occurrences []occurrence
+	for i, term := range terms {
+		for _, position := range positions[term] {
+			occurrences = append(occurrences, occurrence{position, i})
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].position < occurrences[j].position
+	})
+
+	var starts []int
+	counts := make([]int, len(terms))
+	covered, left := 0, 0
+	for _, o := range occurrences {
+		if counts[o.term] == 0 {
+			covered++
+		}
+		counts[o.term]++
+		for covered == len(terms) {
+			first := occurrences[left]
+			if counts[first.term] == 1 {
+				if o.position-first.position <= within {
+					starts = append(starts, first.position)
+				}
+				covered--
+			}
+			counts[first.term]--
+			left++
+		}
+	}
+	return starts
+}
@@ -0,0 +1,103 @@
+package words
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected Query
+	}{
+		{query: "barrels island", expected: Query{Terms: []string{"barrel", "island"}}},
+		{
+			query: `boy "correct horse battery staple"`,
+			expected: Query{
+				Terms:   []string{"boy", "correct", "hors", "batteri", "stapl"},
+				Phrases: []Phrase{{Terms: []string{"correct", "hors", "batteri", "stapl"}}},
+			},
+		},
+		{
+			query: `"horse staple"~3 raft`,
+			expected: Query{
+				Terms:   []string{"hors", "stapl", "raft"},
+				Phrases: []Phrase{{Terms: []string{"hors", "stapl"}, Within: 3}},
+			},
+		},
+		{query: `"island"`, expected: Query{Terms: []string{"island"}}},
+		{
+			query: `raft "open ended phrase`,
+			expected: Query{
+				Terms:   []string{"raft", "open", "end", "phrase"},
+				Phrases: []Phrase{{Terms: []string{"open", "end", "phrase"}}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		if got := ParseQuery(tc.query); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("ParseQuery(%q): expected %+v, got %+v", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestPhraseSearch(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, []string{"correct", "hors", "batteri", "stapl"})
+	index.Add(2, []string{"stapl", "batteri", "hors", "correct", "stapl", "hors"})
+	index.Add(3, []string{"hors", "batteri", "long", "wind", "stapl"})
+	index.Add(4, []string{"hors"})
+
+	testCases := []struct {
+		phrase   Phrase
+		expected []Posting
+	}{
+		{
+			phrase:   Phrase{Terms: []string{"correct", "hors", "batteri", "stapl"}},
+			expected: []Posting{{ID: 1, Freq: 1, Positions: []int{0}}},
+		},
+		{
+			phrase:   Phrase{Terms: []string{"hors", "batteri"}},
+			expected: []Posting{{ID: 1, Freq: 1, Positions: []int{1}}, {ID: 3, Freq: 1, Positions: []int{0}}},
+		},
+		{
+			phrase:   Phrase{Terms: []string{"stapl", "hors"}, Within: 3},
+			expected: []Posting{{ID: 1, Freq: 1, Positions: []int{1}}, {ID: 2, Freq: 3, Positions: []int{0, 2, 4}}},
+		},
+		{phrase: Phrase{Terms: []string{"hors", "volcano"}}, expected: nil},
+	}
+	for _, tc := range testCases {
+		if got := PhrasePostings(index, tc.phrase); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Phrase %v: expected %v, got %v", tc.phrase, tc.expected, got)
+		}
+	}
+
+	matches := PhrasePostings(index, Phrase{Terms: []string{"stapl", "hors"}, Within: 1})
+	if len(matches) != 1 || matches[0].ID != 2 || matches[0].Freq != 1 || matches[0].Positions[0] != 4 {
+		t.Errorf("Expected one proximity match in comic 2 at 4, got %v", matches)
+	}
+
+	queries := []struct {
+		query    string
+		expected []int
+		phrases  []int
+	}{
+		// Comic 1 has the phrase; the others only have some of the words.
+		{query: `"correct horse battery staple"`, expected: []int{1, 2, 3, 4}, phrases: []int{1, 0, 0, 0}},
+		{query: `"horse battery"`, expected: []int{1, 3, 2, 4}, phrases: []int{1, 1, 0, 0}},
+		{query: `"staple horse"~1`, expected: []int{2, 1, 3, 4}, phrases: []int{1, 0, 0, 0}},
+		{query: `"staple horse"~3`, expected: []int{2, 1, 3, 4}, phrases: []int{1, 1, 0, 0}},
+		{query: `"winding staple" "horse battery"`, expected: []int{3, 1, 2, 4}, phrases: []int{2, 1, 0, 0}},
+	}
+	for _, tc := range queries {
+		results := SearchIndex(tc.query, index)
+		ids := make([]int, len(results))
+		phrases := make([]int, len(results))
+		for i, result := range results {
+			ids[i], phrases[i] = result.ID, result.Phrases
+		}
+		if !reflect.DeepEqual(ids, tc.expected) || !reflect.DeepEqual(phrases, tc.phrases) {
+			t.Errorf("Query %s: expected %v with phrases %v, got %v", tc.query, tc.expected, tc.phrases, results)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package words
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BM25 parameters: bm25K1 bounds how much repeating a term raises the score
+// and bm25B how much long comics are penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Stats are the collection statistics BM25 needs.
+type Stats struct {
+	Docs      int
+	AvgLength float64
+}
+
+func makeStats(lengths map[int]int) Stats {
+	stats := Stats{Docs: len(lengths)}
+	if stats.Docs == 0 {
+		return stats
+	}
+	total := 0
+	for _, length := range lengths {
+		total += length
+	}
+	stats.AvgLength = float64(total) / float64(stats.Docs)
+	return stats
+}
+
+// PostingsSource is an index that can be searched. It is implemented by
+// Index and by MappedIndex.
+type PostingsSource interface {
+	Postings(term string) []Posting
+	// DocLength returns the number of keywords of a comic.
+	DocLength(id int) int
+	Stats() Stats
+	// Vocabulary returns all terms.
+	Vocabulary() []string
+	// Field returns the index of a single field of the comics.
+	Field(name string) PostingsSource
+}
+
+// Result is a comic matching a query and its BM25 score.
+type Result struct {
+	ID    int
+	Score float64
+	// Phrases is the number of phrases of the query the comic contains.
+	Phrases int
+	// Fields is the part of the score each field matched contributed, if
+	// the fields were searched separately.
+	Fields map[string]float64
+}
+
+// SearchIndex ranks the comics matching any word of the query, see
+// ParseQuery, the best first. It is the plain ranking without the boolean
+// operators and fields of the search package.
+func SearchIndex(query string, index PostingsSource) []Result {
+	return SearchQuery(ParseQuery(query), index)
+}
+
+// SearchQuery ranks the comics matching any term of q by BM25. Comics
+// containing more of the phrases of q come first; a phrase adds to the score
+// like a term whose occurrences are the phrase matches. Synonyms of the terms
+// and phrases match as well, with their score scaled by SynonymWeight.
+// Repeated terms and phrases count once.
+func SearchQuery(q Query, index PostingsSource) []Result {
+	results := make(map[int]*Result)
+	seen := make(map[string]bool)
+	add := func(phrase Phrase, weight float64, counts bool) {
+		key := strings.Join(phrase.Terms, " ") + "~" + strconv.Itoa(phrase.Within)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		var postings []Posting
+		if len(phrase.Terms) == 1 {
+			postings = index.Postings(phrase.Terms[0])
+		} else {
+			postings = PhrasePostings(index, phrase)
+		}
+		for _, match := range ScorePostings(index, postings) {
+			result, ok := results[match.ID]
+			if !ok {
+				result = &Result{ID: match.ID}
+				results[match.ID] = result
+			}
+			result.Score += weight * match.Score
+			if counts {
+				result.Phrases++
+			}
+		}
+	}
+
+	for _, term := range q.Terms {
+		add(Phrase{Terms: []string{term}}, 1, false)
+	}
+	for _, phrase := range q.Phrases {
+		add(phrase, 1, true)
+	}
+	for _, synonym := range Synonyms(q.Terms) {
+		add(Phrase{Terms: synonym.Terms}, SynonymWeight, false)
+	}
+	for _, phrase := range q.Phrases {
+		for _, synonym := range Synonyms(phrase.Terms) {
+			add(Phrase{Terms: synonym.Substitute(phrase.Terms), Within: phrase.Within}, SynonymWeight, false)
+		}
+	}
+
+	sorted := make([]Result, 0, len(results))
+	for _, result := range results {
+		sorted = append(sorted, *result)
+	}
+	SortResults(sorted)
+	return sorted
+}
+
+// ScorePostings returns the BM25 score of every comic in the postings of a
+// term, or of a phrase, found in index.
+func ScorePostings(index PostingsSource, postings []Posting) []Result {
+	if len(postings) == 0 {
+		return nil
+	}
+	stats := index.Stats()
+	idf := IDF(stats.Docs, len(postings))
+	results := make([]Result, len(postings))
+	for i, p := range postings {
+		results[i] = Result{ID: p.ID, Score: idf * termWeight(p.Freq, index.DocLength(p.ID), stats.AvgLength)}
+	}
+	return results
+}
+
+// IDF is the BM25 inverse document frequency of a term found in docFreq of docs comics.
+func IDF(docs, docFreq int) float64 {
+	return math.Log(1 + (float64(docs)-float64(docFreq)+0.5)/(float64(docFreq)+0.5))
+}
+
+// termWeight is the BM25 weight of a term occurring freq times in a comic of
+// the given length.
+func termWeight(freq, length int, avgLength float64) float64 {
+	norm := 1.0
+	if avgLength > 0 {
+		norm = 1 - bm25B + bm25B*float64(length)/avgLength
+	}
+	tf := float64(freq)
+	return tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+}
+
+// SortResults orders results by the number of matched phrases and the score,
+// both descending, then by comic number.
+func SortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Phrases != results[j].Phrases {
+			return results[i].Phrases > results[j].Phrases
+		}
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+}
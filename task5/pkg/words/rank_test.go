@@ -0,0 +1,117 @@
+package words
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, NormalizeInput("barrel barrel barrel"))
+	index.Add(2, NormalizeInput("barrel island"))
+	index.Add(3, NormalizeInput("island boy"))
+	index.Add(4, NormalizeInput("barrel island boat raft ocean wave"))
+	index.Add(5, NormalizeInput("barrel island"))
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{name: "frequent term ranks higher", query: "barrel", expected: []int{1, 2, 5, 4}},
+		{name: "rare term outweighs common one", query: "barrel boy", expected: []int{3, 1, 2, 5, 4}},
+		{name: "repeated query term counts once", query: "island island boy", expected: []int{3, 2, 5, 4}},
+		{name: "no match", query: "volcano", expected: []int{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			results := SearchIndex(tc.query, index)
+			ids := make([]int, len(results))
+			for i, result := range results {
+				ids[i] = result.ID
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Fatalf("Expected %v, got %v", tc.expected, results)
+			}
+		})
+	}
+
+	// Comics 2 and 5 are identical, so they tie and are ordered by number.
+	results := SearchIndex("barrel island", index)
+	if results[0].ID != 2 || results[1].ID != 5 || results[0].Score != results[1].Score {
+		t.Errorf("Expected a tie between comics 2 and 5, got %v", results)
+	}
+}
+
+func TestSearchIndexSynonyms(t *testing.T) {
+	loadTestSynonyms(t, "ocean, sea\nboat => raft\n")
+	index := NewIndex()
+	index.Add(1, NormalizeInput("sea"))
+	index.Add(2, NormalizeInput("ocean"))
+	index.Add(3, NormalizeInput("raft"))
+
+	// A synonym matches below the word itself, and one-way rules only apply forwards.
+	testCases := []struct {
+		query    string
+		expected []int
+	}{
+		{query: "ocean", expected: []int{2, 1}},
+		{query: "boat", expected: []int{3}},
+		{query: "raft", expected: []int{3}},
+	}
+	for _, tc := range testCases {
+		results := SearchIndex(tc.query, index)
+		ids := make([]int, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("Query %q: expected %v, got %v", tc.query, tc.expected, results)
+		}
+	}
+}
+
+func TestScorePostings(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, NormalizeInput("barrel barrel barrel"))
+	index.Add(2, NormalizeInput("barrel island"))
+	index.Add(3, NormalizeInput("island boy"))
+	index.Add(4, NormalizeInput("barrel island boat raft ocean wave"))
+	index.Add(5, NormalizeInput("barrel island"))
+
+	// A frequent term ranks higher and long comics lower. Comics 2 and 5
+	// are identical, so they tie and are ordered by number.
+	results := ScorePostings(index, index.Postings("barrel"))
+	SortResults(results)
+	ids := make([]int, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 5, 4}) || results[1].Score != results[2].Score {
+		t.Errorf("Expected [1 2 5 4] with a tie between 2 and 5, got %v", results)
+	}
+
+	// A single match of a term found in one of five comics with average length.
+	stats := index.Stats()
+	want := IDF(5, 1) * (bm25K1 + 1) / (1 + bm25K1*(1-bm25B+bm25B*2/stats.AvgLength))
+	if got := ScorePostings(index, index.Postings("boy"))[0].Score; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected score %v, got %v", want, got)
+	}
+
+	if results := ScorePostings(index, index.Postings("volcano")); len(results) != 0 {
+		t.Errorf("Expected no results, got %v", results)
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	results := []Result{{ID: 3, Score: 1}, {ID: 1, Score: 2}, {ID: 2, Score: 0.5, Phrases: 1}, {ID: 0, Score: 1}}
+	SortResults(results)
+	ids := make([]int, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	if !reflect.DeepEqual(ids, []int{2, 1, 0, 3}) {
+		t.Errorf("Expected phrases, then score, then number to decide, got %v", ids)
+	}
+}
@@ -0,0 +1,111 @@
+package words
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxSuggestions is the number of completions kept for every prefix.
+const MaxSuggestions = 10
+
+// Suggestion is a completion of what was typed and the number of comics
+// containing its last word.
+type Suggestion struct {
+	Text string
+	Docs int
+}
+
+// Suggester completes the last word of a query from the words of an index.
+// It is a trie whose nodes keep the best completions of their prefix, so a
+// lookup only walks the typed prefix.
+type Suggester struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// top are the completions of the prefix ending at the node, the ones
+	// in the most comics first.
+	top []Suggestion
+}
+
+// NewSuggester builds a suggester from the surface forms of index. Terms no
+// form of which is known, because they were indexed before forms were
+// recorded, are suggested as the stems they are stored as.
+func NewSuggester(index Index) *Suggester {
+	words := surfaceWords(index)
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Docs != words[j].Docs {
+			return words[i].Docs > words[j].Docs
+		}
+		return words[i].Text < words[j].Text
+	})
+
+	// Words are inserted best first, so the first ones to reach a node are
+	// the best completions of its prefix.
+	s := &Suggester{root: &trieNode{}}
+	for _, word := range words {
+		node := s.root
+		for _, r := range word.Text {
+			child, ok := node.children[r]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[rune]*trieNode)
+				}
+				child = &trieNode{}
+				node.children[r] = child
+			}
+			node = child
+			if len(node.top) < MaxSuggestions {
+				node.top = append(node.top, word)
+			}
+		}
+	}
+	return s
+}
+
+// Suggest returns up to limit completions of the last word of input, each
+// with the text before that word in front of it. Nothing is suggested
+// until a word has been started.
+func (s *Suggester) Suggest(input string, limit int) []Suggestion {
+	fields := strings.Fields(input)
+	if len(fields) == 0 || strings.TrimRightFunc(input, unicode.IsSpace) != input {
+		return nil
+	}
+	last := fields[len(fields)-1]
+	head := input[:len(input)-len(last)]
+
+	node := s.root
+	for _, r := range strings.ToLower(last) {
+		if node = node.children[r]; node == nil {
+			return nil
+		}
+	}
+	top := node.top
+	if limit < len(top) {
+		top = top[:limit]
+	}
+	suggestions := make([]Suggestion, len(top))
+	for i, word := range top {
+		suggestions[i] = Suggestion{Text: head + word.Text, Docs: word.Docs}
+	}
+	return suggestions
+}
+
+// surfaceWords returns the surface forms of index and the terms no form of
+// which is known with the number of comics containing them.
+func surfaceWords(index Index) []Suggestion {
+	words := make([]Suggestion, 0, len(index.Forms))
+	stems := make(map[string]bool, len(index.Forms))
+	for form, ids := range index.Forms {
+		words = append(words, Suggestion{Text: form, Docs: len(ids)})
+		stems[Stem(form)] = true
+	}
+	for term, postings := range index.Terms {
+		if !stems[term] {
+			words = append(words, Suggestion{Text: term, Docs: len(postings)})
+		}
+	}
+	return words
+}
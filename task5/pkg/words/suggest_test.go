@@ -0,0 +1,47 @@
+package words
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSurfaceForms(t *testing.T) {
+	forms := SurfaceForms("Barrels, barrels and 42 Island-hopping")
+	expected := []string{"barrels", "and", "islandhopping"}
+	if !reflect.DeepEqual(forms, expected) {
+		t.Errorf("Expected %v, got %v", expected, forms)
+	}
+}
+
+func TestSuggester(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, []string{"python", "program"})
+	index.Add(2, []string{"python", "program"})
+	index.Add(3, []string{"pythagora", "raptor"})
+	index.AddForms(1, []string{"python", "programming"})
+	index.AddForms(2, []string{"pythons", "programs"})
+	index.AddForms(3, []string{"pythagoras"})
+	index.AddForms(2, []string{"python"})
+	suggester := NewSuggester(index)
+
+	testCases := []struct {
+		input    string
+		limit    int
+		expected []Suggestion
+	}{
+		{input: "pyt", limit: 10, expected: []Suggestion{{"python", 2}, {"pythagoras", 1}, {"pythons", 1}}},
+		{input: "PYT", limit: 1, expected: []Suggestion{{"python", 2}}},
+		{input: "pythons", limit: 10, expected: []Suggestion{{"pythons", 1}}},
+		{input: "learn Prog", limit: 10, expected: []Suggestion{{"learn programming", 1}, {"learn programs", 1}}},
+		// A term without a known form is suggested as it is stored.
+		{input: "rap", limit: 10, expected: []Suggestion{{"raptor", 1}}},
+		{input: "pyx", limit: 10, expected: nil},
+		{input: "python ", limit: 10, expected: nil},
+		{input: "", limit: 10, expected: nil},
+	}
+	for _, tc := range testCases {
+		if got := suggester.Suggest(tc.input, tc.limit); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Suggest(%q): expected %v, got %v", tc.input, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package words
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SynonymWeight scales the score of a match of a synonym of a query word.
+const SynonymWeight = 0.5
+
+// synonyms maps the keywords of a word or words, joined by spaces, to the
+// keywords of their synonyms. maxSynonymWords is the most words of a key.
+var (
+	synonyms        = make(map[string][][]string)
+	maxSynonymWords = 0
+)
+
+// LoadSynonyms loads synonym rules, one per line. A line listing words
+// separated by commas, such as "math, maths, mathematics", makes them all
+// synonyms of each other. A line such as "ai => artificial intelligence"
+// makes the words on the right synonyms of the ones on the left, but not the
+// other way round. Synonyms can be several words long; text after a # is a
+// comment. Words are normalized like the input, so stop words must be loaded
+// first. The rules replace any loaded before. The file path is taken from
+// SYNONYMS_FILE if not given; without either no synonyms are used.
+func LoadSynonyms(filePath string) error {
+	if filePath == "" {
+		var ok bool
+		filePath, ok = os.LookupEnv("SYNONYMS_FILE")
+		if !ok {
+			return nil
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rules := make(map[string][][]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if err := addSynonymRule(rules, scanner.Text()); err != nil {
+			return fmt.Errorf("%s:%d: %v", filePath, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	synonyms, maxSynonymWords = rules, 0
+	for key := range rules {
+		if words := strings.Count(key, " ") + 1; words > maxSynonymWords {
+			maxSynonymWords = words
+		}
+	}
+	return nil
+}
+
+func addSynonymRule(rules map[string][][]string, rule string) error {
+	if comment := strings.IndexByte(rule, '#'); comment != -1 {
+		rule = rule[:comment]
+	}
+	if strings.TrimSpace(rule) == "" {
+		return nil
+	}
+
+	from, to, oneWay := strings.Cut(rule, "=>")
+	left := synonymWords(from)
+	right := left
+	if oneWay {
+		right = synonymWords(to)
+	}
+	if len(left) == 0 || len(right) == 0 || (!oneWay && len(left) < 2) {
+		return fmt.Errorf("invalid synonym rule %q", strings.TrimSpace(rule))
+	}
+
+	for _, words := range left {
+		key := strings.Join(words, " ")
+		for _, synonym := range right {
+			if strings.Join(synonym, " ") != key && !hasSynonym(rules[key], synonym) {
+				rules[key] = append(rules[key], synonym)
+			}
+		}
+	}
+	return nil
+}
+
+// synonymWords normalizes the comma separated words of a rule, dropping the
+// ones that are only stop words.
+func synonymWords(list string) [][]string {
+	var words [][]string
+	for _, entry := range strings.Split(list, ",") {
+		if keywords := NormalizeInput(entry); len(keywords) > 0 {
+			words = append(words, keywords)
+		}
+	}
+	return words
+}
+
+func hasSynonym(list [][]string, synonym []string) bool {
+	key := strings.Join(synonym, " ")
+	for _, s := range list {
+		if strings.Join(s, " ") == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Synonym is a synonym of Terms[Start:End] of a sequence of keywords.
+type Synonym struct {
+	Start, End int
+	Terms      []string
+}
+
+// Synonyms returns the synonyms of every run of the keywords that has any.
+func Synonyms(terms []string) []Synonym {
+	var found []Synonym
+	for start := range terms {
+		for end := start + 1; end <= len(terms) && end-start <= maxSynonymWords; end++ {
+			for _, synonym := range synonyms[strings.Join(terms[start:end], " ")] {
+				found = append(found, Synonym{Start: start, End: end, Terms: synonym})
+			}
+		}
+	}
+	return found
+}
+
+// Substitute returns terms with the synonym in place of the run it is a synonym of.
+func (s Synonym) Substitute(terms []string) []string {
+	substituted := make([]string, 0, len(terms)-(s.End-s.Start)+len(s.Terms))
+	substituted = append(substituted, terms[:s.Start]...)
+	substituted = append(substituted, s.Terms...)
+	return append(substituted, terms[s.End:]...)
+}
@@ -0,0 +1,61 @@
+package words
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadTestSynonyms loads rules for the duration of a test.
+func loadTestSynonyms(t *testing.T, rules string) {
+	t.Helper()
+	t.Cleanup(func() { synonyms, maxSynonymWords = make(map[string][][]string), 0 })
+	path := filepath.Join(t.TempDir(), "synonyms.txt")
+	if err := os.WriteFile(path, []byte(rules), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSynonyms(path); err != nil {
+		t.Fatalf("Failed to load synonyms: %v", err)
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	loadTestSynonyms(t, `
+# two-way, with a phrase
+ai, artificial intelligence
+math, maths, mathematics  # stems of maths and math are the same
+ml => machine learning
+`)
+
+	testCases := []struct {
+		terms    []string
+		expected []Synonym
+	}{
+		{terms: []string{"ai"}, expected: []Synonym{{Start: 0, End: 1, Terms: []string{"artifici", "intellig"}}}},
+		{terms: []string{"research", "artifici", "intellig"}, expected: []Synonym{{Start: 1, End: 3, Terms: []string{"ai"}}}},
+		{terms: []string{"math"}, expected: []Synonym{{Start: 0, End: 1, Terms: []string{"mathemat"}}}},
+		{terms: []string{"ml"}, expected: []Synonym{{Start: 0, End: 1, Terms: []string{"machin", "learn"}}}},
+		// One-way rules do not apply backwards.
+		{terms: []string{"machin", "learn"}, expected: nil},
+		{terms: []string{"artifici"}, expected: nil},
+	}
+	for _, tc := range testCases {
+		if got := Synonyms(tc.terms); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Synonyms(%v): expected %v, got %v", tc.terms, tc.expected, got)
+		}
+	}
+
+	synonym := Synonyms([]string{"ai"})[0]
+	if got := synonym.Substitute([]string{"ai", "research"}); !reflect.DeepEqual(got, []string{"artifici", "intellig", "research"}) {
+		t.Errorf("Expected the synonym substituted, got %v", got)
+	}
+}
+
+func TestInvalidSynonymRule(t *testing.T) {
+	for _, rule := range []string{"ai", "=> ai", "ai =>"} {
+		if err := addSynonymRule(make(map[string][][]string), rule); err == nil {
+			t.Errorf("Expected rule %q to be rejected", rule)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package xkcd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/Eduard-Bodreev/Yadro/gocomics/pkg/models"
@@ -34,10 +35,16 @@ func (c *Client) FetchComic(id int) (*models.Comic, error) {
 		return nil, fmt.Errorf("received non-200 response status: %d", resp.StatusCode)
 	}
 
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading comic: %v", err)
+	}
+
 	var comic models.Comic
-	if err := json.NewDecoder(resp.Body).Decode(&comic); err != nil {
+	if err := json.Unmarshal(data, &comic); err != nil {
 		return nil, fmt.Errorf("error decoding comic: %v", err)
 	}
+	comic.Raw = data
 
 	return &comic, nil
 }